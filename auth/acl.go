@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/imhasandl/vk-internship/subpub"
+)
+
+// Action - действие клиента над темой
+type Action string
+
+const (
+	ActionPublish   Action = "publish"
+	ActionSubscribe Action = "subscribe"
+)
+
+// anyValue в правиле означает любую идентичность или любое действие
+const anyValue = "*"
+
+// Authorizer решает, может ли клиент выполнить действие над темой или
+// шаблоном тем
+type Authorizer interface {
+	Allowed(id Identity, action Action, pattern string) bool
+}
+
+// Rule - правило доступа из файла политики:
+//
+//	{"identities": ["billing"], "actions": ["publish"], "subjects": ["orders.>"], "effect": "allow"}
+type Rule struct {
+	// Identities - имена клиентов, "*" - любой клиент
+	Identities []string `json:"identities"`
+	// Actions - publish, subscribe или "*"
+	Actions []string `json:"actions"`
+	// Subjects - темы и шаблоны тем с подстановками * и >
+	Subjects []string `json:"subjects"`
+	// Effect - allow или deny
+	Effect string `json:"effect"`
+}
+
+// Policy - список правил доступа. Действие разрешено, если его разрешает хотя
+// бы одно правило и не запрещает ни одно: запрет сильнее разрешения, а все,
+// что не разрешено явно, запрещено.
+type Policy struct {
+	rules []rule
+}
+
+type rule struct {
+	identities []string
+	actions    []string
+	subjects   [][]string
+	deny       bool
+}
+
+type policyFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadPolicy читает политику из JSON файла вида {"rules": [...]}
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("auth: parse %s: %w", path, err)
+	}
+	p, err := NewPolicy(file.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// NewPolicy проверяет правила и создает политику
+func NewPolicy(rules []Rule) (*Policy, error) {
+	p := &Policy{rules: make([]rule, 0, len(rules))}
+	for i, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func compileRule(r Rule) (rule, error) {
+	var compiled rule
+	switch r.Effect {
+	case "allow":
+	case "deny":
+		compiled.deny = true
+	default:
+		return rule{}, fmt.Errorf("unknown effect %q", r.Effect)
+	}
+
+	if len(r.Identities) == 0 {
+		return rule{}, fmt.Errorf("identities are required")
+	}
+	compiled.identities = r.Identities
+
+	if len(r.Actions) == 0 {
+		return rule{}, fmt.Errorf("actions are required")
+	}
+	for _, action := range r.Actions {
+		switch Action(action) {
+		case ActionPublish, ActionSubscribe, anyValue:
+		default:
+			return rule{}, fmt.Errorf("unknown action %q", action)
+		}
+	}
+	compiled.actions = r.Actions
+
+	if len(r.Subjects) == 0 {
+		return rule{}, fmt.Errorf("subjects are required")
+	}
+	for _, subject := range r.Subjects {
+		if err := subpub.ValidatePattern(subject); err != nil {
+			return rule{}, err
+		}
+		compiled.subjects = append(compiled.subjects, strings.Split(subject, "."))
+	}
+	return compiled, nil
+}
+
+// Allowed проверяет действие над темой или шаблоном. Шаблон подписки
+// разрешен, только если все его темы попадают под разрешающее правило,
+// и запрещен, если хотя бы одна из них попадает под запрещающее.
+func (p *Policy) Allowed(id Identity, action Action, pattern string) bool {
+	tokens := strings.Split(pattern, ".")
+	allowed := false
+	for _, r := range p.rules {
+		if !r.applies(id, action) {
+			continue
+		}
+		if r.deny {
+			if slices.ContainsFunc(r.subjects, func(s []string) bool { return intersects(tokens, s) }) {
+				return false
+			}
+			continue
+		}
+		if !allowed {
+			allowed = slices.ContainsFunc(r.subjects, func(s []string) bool { return subset(tokens, s) })
+		}
+	}
+	return allowed
+}
+
+func (r rule) applies(id Identity, action Action) bool {
+	return (slices.Contains(r.identities, anyValue) || slices.Contains(r.identities, id.Name)) &&
+		(slices.Contains(r.actions, anyValue) || slices.Contains(r.actions, string(action)))
+}
+
+// subset сообщает, входит ли каждая тема, совпадающая с шаблоном p,
+// в шаблон q
+func subset(p, q []string) bool {
+	for i, token := range q {
+		if token == ">" {
+			// > совпадает с одной и более лексемами
+			return i < len(p)
+		}
+		if i >= len(p) || p[i] == ">" {
+			return false
+		}
+		if token == "*" {
+			continue
+		}
+		if p[i] != token {
+			// Лексема q конкретная, а * в p совпадает и с другими
+			return false
+		}
+	}
+	return len(p) == len(q)
+}
+
+// intersects сообщает, есть ли тема, совпадающая с обоими шаблонами
+func intersects(p, q []string) bool {
+	for i := 0; i < len(p) && i < len(q); i++ {
+		if p[i] == ">" || q[i] == ">" {
+			return true
+		}
+		if p[i] == "*" || q[i] == "*" {
+			continue
+		}
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return len(p) == len(q)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy([]Rule{
+		{Identities: []string{"billing"}, Actions: []string{"publish"}, Subjects: []string{"orders.>"}, Effect: "allow"},
+		{Identities: []string{"billing"}, Actions: []string{"publish"}, Subjects: []string{"orders.internal.*"}, Effect: "deny"},
+		{Identities: []string{"*"}, Actions: []string{"subscribe"}, Subjects: []string{"orders.*.created", "news"}, Effect: "allow"},
+		{Identities: []string{"guest"}, Actions: []string{"*"}, Subjects: []string{"orders.us.*"}, Effect: "deny"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		id      string
+		action  Action
+		subject string
+		want    bool
+	}{
+		{"Публикация по разрешению", "billing", ActionPublish, "orders.eu.created", true},
+		{"Запрет сильнее разрешения", "billing", ActionPublish, "orders.internal.audit", false},
+		{"Тема вне разрешения", "billing", ActionPublish, "payments.eu", false},
+		{"Действие вне разрешения", "billing", ActionSubscribe, "orders.eu.paid", false},
+		{"Неизвестный клиент", "intruder", ActionPublish, "orders.eu.created", false},
+		{"Подписка любого клиента", "intruder", ActionSubscribe, "orders.eu.created", true},
+		{"Шаблон внутри разрешения", "intruder", ActionSubscribe, "orders.*.created", true},
+		{"Шаблон шире разрешения", "intruder", ActionSubscribe, "orders.>", false},
+		{"Шаблон пересекает запрет", "guest", ActionSubscribe, "orders.*.created", false},
+		{"Тема рядом с запретом", "guest", ActionSubscribe, "orders.eu.created", true},
+		{"Тема под запретом", "guest", ActionSubscribe, "orders.us.created", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.Allowed(Identity{Name: tt.id}, tt.action, tt.subject))
+		})
+	}
+}
+
+func TestPatternRelations(t *testing.T) {
+	tests := []struct {
+		p, q           string
+		wantSubset     bool
+		wantIntersects bool
+	}{
+		{"a.b", "a.b", true, true},
+		{"a.b", "a.c", false, false},
+		{"a.b", "a.*", true, true},
+		{"a.*", "a.b", false, true},
+		{"a.*", "a.*", true, true},
+		{"a.b.c", "a.>", true, true},
+		{"a", "a.>", false, false},
+		{"a.>", "a.*", false, true},
+		{"a.*.c", "a.>", true, true},
+		{"a.>", "a.>", true, true},
+		{">", "a.*", false, true},
+		{"*.b", "a.*", false, true},
+		{"a.b", "a.b.c", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.p+" "+tt.q, func(t *testing.T) {
+			p, q := strings.Split(tt.p, "."), strings.Split(tt.q, ".")
+			assert.Equal(t, tt.wantSubset, subset(p, q))
+			assert.Equal(t, tt.wantIntersects, intersects(p, q))
+			assert.Equal(t, tt.wantIntersects, intersects(q, p))
+		})
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	valid := Rule{Identities: []string{"*"}, Actions: []string{"publish"}, Subjects: []string{"orders"}, Effect: "allow"}
+
+	tests := []struct {
+		name   string
+		modify func(r *Rule)
+	}{
+		{"Неизвестное действие", func(r *Rule) { r.Actions = []string{"delete"} }},
+		{"Неизвестный эффект", func(r *Rule) { r.Effect = "maybe" }},
+		{"Нет тем", func(r *Rule) { r.Subjects = nil }},
+		{"Нет клиентов", func(r *Rule) { r.Identities = nil }},
+		{"Некорректный шаблон", func(r *Rule) { r.Subjects = []string{"orders.>.eu"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.modify(&r)
+			_, err := NewPolicy([]Rule{r})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package auth отвечает за подлинность клиентов и их права: TLS сервера,
+// сертификаты и токены клиентов, их идентичность в контексте запроса и
+// правила доступа к темам.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Способы, которыми клиент подтвердил свою личность
+const (
+	MethodTLS = "tls"
+)
+
+// Identity - клиент, от имени которого выполняется запрос
+type Identity struct {
+	// Name - имя клиента, например CN его сертификата
+	Name string
+	// Method - как клиент подтвердил свою личность
+	Method string
+	// Certificate - проверенный сертификат клиента при mTLS
+	Certificate *x509.Certificate
+}
+
+type identityKey struct{}
+
+// WithIdentity добавляет идентичность клиента в контекст запроса
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext возвращает идентичность клиента: заданную через WithIdentity,
+// а если ее нет - из проверенного сертификата клиента
+func FromContext(ctx context.Context) (Identity, bool) {
+	if id, ok := ctx.Value(identityKey{}).(Identity); ok {
+		return id, true
+	}
+	return PeerIdentity(ctx)
+}
+
+// PeerIdentity возвращает идентичность клиента по сертификату, который
+// сервер проверил при mTLS. Непроверенные сертификаты не учитываются.
+func PeerIdentity(ctx context.Context) (Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	return Identity{Name: certificateName(cert), Method: MethodTLS, Certificate: cert}, true
+}
+
+// certificateName выбирает имя клиента: CN, а если он пуст - первый URI
+// (например, SPIFFE ID), DNS имя или адрес почты из SAN
+func certificateName(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationHeader - метаданные с токеном вида "Bearer <token>"
+const authorizationHeader = "authorization"
+
+// Authenticator проверяет токены клиентов в перехватчиках gRPC и добавляет
+// их идентичность в контекст запроса
+type Authenticator struct {
+	verifier TokenVerifier
+}
+
+// NewAuthenticator создает проверку токенов. Клиент без токена допускается,
+// только если он подтвердил личность сертификатом при mTLS.
+func NewAuthenticator(verifier TokenVerifier) *Authenticator {
+	return &Authenticator{verifier: verifier}
+}
+
+// UnaryInterceptor проверяет токен перед обработкой унарного запроса
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor проверяет токен при открытии потока
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	token, ok := bearerToken(ctx)
+	if !ok {
+		if _, ok := PeerIdentity(ctx); ok {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	id, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return WithIdentity(ctx, id), nil
+}
+
+// bearerToken читает токен из заголовка authorization. Заголовок с другой
+// схемой дает пустой токен, который не пройдет проверку.
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return "", true
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticatedStream подменяет контекст потока контекстом с идентичностью
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
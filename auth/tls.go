@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultReloadInterval - как часто по умолчанию проверяются файлы сертификатов
+const defaultReloadInterval = 10 * time.Second
+
+// TLSOptions - настройки TLS сервера
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile включает mTLS: клиент предъявляет сертификат, подписанный этим CA
+	ClientCAFile string
+	// ClientAuth задает проверку сертификата клиента при mTLS,
+	// по умолчанию сертификат обязателен
+	ClientAuth tls.ClientAuthType
+	// ReloadInterval - как часто проверять, не обновились ли файлы
+	ReloadInterval time.Duration
+	Logger         *slog.Logger
+}
+
+// ParseClientAuth разбирает режим проверки клиента: "require" - сертификат
+// обязателен, "optional" - проверяется, если клиент его предъявил
+func ParseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch value {
+	case "require", "":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q", value)
+	}
+}
+
+// NewTLSConfig создает настройки TLS сервера. Сертификат, ключ и CA клиентов
+// перечитываются при изменении файлов, поэтому их можно обновить без
+// перезапуска. Новые файлы действуют для новых соединений.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("auth: certificate and key files are required")
+	}
+	if opts.ClientCAFile != "" && opts.ClientAuth == tls.NoClientCert {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = defaultReloadInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	r := &reloader{opts: opts}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}, nil
+}
+
+// reloader хранит настройки TLS и обновляет их, когда меняются файлы
+type reloader struct {
+	opts TLSOptions
+
+	mu      sync.Mutex
+	config  *tls.Config
+	files   map[string]fileVersion
+	checked time.Time
+}
+
+// fileVersion отличает обновленный файл от прежнего
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func (r *reloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.opts.ReloadInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			// При ошибке, например пока файл дописывается, работаем со старыми настройками
+			if err := r.load(); err != nil {
+				r.opts.Logger.Warn("failed to reload TLS certificates", slog.Any("error", err))
+			} else {
+				r.opts.Logger.Info("TLS certificates reloaded")
+			}
+		}
+	}
+	return r.config, nil
+}
+
+// changed сообщает, изменился ли какой-нибудь из файлов
+func (r *reloader) changed() bool {
+	for name, version := range r.files {
+		current, err := stat(name)
+		if err != nil || current != version {
+			return true
+		}
+	}
+	return false
+}
+
+// load читает файлы и строит новые настройки
+func (r *reloader) load() error {
+	files := make(map[string]fileVersion)
+	for _, name := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if name == "" {
+			continue
+		}
+		version, err := stat(name)
+		if err != nil {
+			return err
+		}
+		files[name] = version
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("auth: load certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("auth: read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("auth: no certificates in %s", r.opts.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = r.opts.ClientAuth
+	}
+
+	r.config = config
+	r.files = files
+	return nil
+}
+
+func stat(name string) (fileVersion, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA - удостоверяющий центр, созданный в памяти для теста
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue выпускает сертификат сервера или клиента и возвращает его и ключ в PEM
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// clientConfig - настройки TLS клиента, доверяющего ca
+func (ca *testCA) clientConfig(t *testing.T, cert []tls.Certificate) *tls.Config {
+	t.Helper()
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(ca.pem))
+	return &tls.Config{RootCAs: pool, ServerName: "localhost", Certificates: cert}
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+// serverFiles выпускает сертификат сервера и записывает его в dir
+func serverFiles(t *testing.T, ca *testCA, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageServerAuth)
+	return writeFile(t, dir, "server.crt", certPEM), writeFile(t, dir, "server.key", keyPEM)
+}
+
+// startTLSServer запускает gRPC сервер и возвращает адрес и идентичность
+// клиента последнего запроса
+func startTLSServer(t *testing.T, config *tls.Config) (string, func() (Identity, bool)) {
+	t.Helper()
+	type result struct {
+		id Identity
+		ok bool
+	}
+	last := make(chan result, 1)
+
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(config)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			id, ok := FromContext(ctx)
+			last <- result{id, ok}
+			return handler(ctx, req)
+		}),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String(), func() (Identity, bool) {
+		r := <-last
+		return r.id, r.ok
+	}
+}
+
+func check(t *testing.T, addr string, config *tls.Config) error {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+
+	clientCert := func(ca *testCA, name string) []tls.Certificate {
+		certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageClientAuth)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		return []tls.Certificate{cert}
+	}
+
+	tests := []struct {
+		name       string
+		mtls       bool
+		clientAuth string
+		clientCert []tls.Certificate
+		wantErr    bool
+		wantID     string
+	}{
+		{
+			name: "TLS без сертификата клиента",
+		},
+		{
+			name:       "mTLS с сертификатом клиента",
+			mtls:       true,
+			clientCert: clientCert(ca, "billing"),
+			wantID:     "billing",
+		},
+		{
+			name:    "mTLS без сертификата клиента",
+			mtls:    true,
+			wantErr: true,
+		},
+		{
+			name:       "Сертификат чужого CA",
+			mtls:       true,
+			clientCert: clientCert(otherCA, "intruder"),
+			wantErr:    true,
+		},
+		{
+			name:       "Необязательный сертификат клиента",
+			mtls:       true,
+			clientAuth: "optional",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := TLSOptions{}
+			opts.CertFile, opts.KeyFile = serverFiles(t, ca, dir, "server")
+			if tt.mtls {
+				opts.ClientCAFile = writeFile(t, dir, "ca.crt", ca.pem)
+				var err error
+				opts.ClientAuth, err = ParseClientAuth(tt.clientAuth)
+				require.NoError(t, err)
+			}
+			config, err := NewTLSConfig(opts)
+			require.NoError(t, err)
+
+			addr, lastIdentity := startTLSServer(t, config)
+			err = check(t, addr, ca.clientConfig(t, tt.clientCert))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			id, ok := lastIdentity()
+			assert.Equal(t, tt.wantID != "", ok)
+			assert.Equal(t, tt.wantID, id.Name)
+			if ok {
+				assert.Equal(t, MethodTLS, id.Method)
+			}
+		})
+	}
+}
+
+// TestTLSReload проверяет, что обновленный сертификат подхватывается без перезапуска
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := serverFiles(t, ca, dir, "old")
+
+	config, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond})
+	require.NoError(t, err)
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	serverName := func() string {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), ca.clientConfig(t, nil))
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "old", serverName())
+
+	// Битый файл не ломает сервер: остаются прежние сертификаты
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	assert.Equal(t, "old", serverName())
+
+	serverFiles(t, ca, dir, "new")
+	// Время изменения могло совпасть с прежним, поэтому сдвигаем его явно
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	assert.Equal(t, "new", serverName())
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{value: "", want: tls.RequireAndVerifyClientCert},
+		{value: "require", want: tls.RequireAndVerifyClientCert},
+		{value: "optional", want: tls.VerifyClientCertIfGiven},
+		{value: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseClientAuth(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
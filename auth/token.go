@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// Способы, которыми клиент подтвердил свою личность токеном
+const (
+	MethodToken = "token"
+	MethodJWT   = "jwt"
+)
+
+// ErrInvalidToken возвращается для неизвестного, просроченного или
+// неправильно подписанного токена
+var ErrInvalidToken = errors.New("auth: invalid token")
+
+// TokenVerifier проверяет bearer токен и возвращает идентичность клиента
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (Identity, error)
+}
+
+// StaticTokens - токены из файла, каждому соответствует имя клиента
+type StaticTokens struct {
+	// хранятся хеши, чтобы сравнение не зависело от содержимого токена
+	names map[[sha256.Size]byte]string
+}
+
+// staticTokensFile - формат файла токенов:
+//
+//	{"tokens": [{"token": "s3cr3t", "name": "billing"}]}
+type staticTokensFile struct {
+	Tokens []struct {
+		Token string `json:"token"`
+		Name  string `json:"name"`
+	} `json:"tokens"`
+}
+
+// LoadStaticTokens читает файл токенов
+func LoadStaticTokens(path string) (*StaticTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file staticTokensFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("auth: parse %s: %w", path, err)
+	}
+
+	t := &StaticTokens{names: make(map[[sha256.Size]byte]string, len(file.Tokens))}
+	for i, entry := range file.Tokens {
+		if entry.Token == "" || entry.Name == "" {
+			return nil, fmt.Errorf("auth: %s: token %d: token and name are required", path, i+1)
+		}
+		t.names[sha256.Sum256([]byte(entry.Token))] = entry.Name
+	}
+	return t, nil
+}
+
+func (t *StaticTokens) Verify(_ context.Context, token string) (Identity, error) {
+	name, ok := t.names[sha256.Sum256([]byte(token))]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{Name: name, Method: MethodToken}, nil
+}
+
+// jwtAlgorithms - алгоритмы подписи, которые принимает JWTVerifier
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// jwtLeeway - допустимое расхождение часов при проверке exp и nbf
+const jwtLeeway = time.Minute
+
+// JWTVerifier проверяет JWT по ключам из локального JWKS. Имя клиента
+// берется из claim sub.
+type JWTVerifier struct {
+	keys     jose.JSONWebKeySet
+	issuer   string
+	audience string
+}
+
+// JWTOptions - ожидаемые издатель и получатель токенов, пустые не проверяются
+type JWTOptions struct {
+	Issuer   string
+	Audience string
+}
+
+// LoadJWKS читает JWKS из файла
+func LoadJWKS(path string, opts JWTOptions) (*JWTVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &JWTVerifier{issuer: opts.Issuer, audience: opts.Audience}
+	if err := json.Unmarshal(data, &v.keys); err != nil {
+		return nil, fmt.Errorf("auth: parse %s: %w", path, err)
+	}
+	if len(v.keys.Keys) == 0 {
+		return nil, fmt.Errorf("auth: %s: no keys", path)
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) Verify(_ context.Context, token string) (Identity, error) {
+	parsed, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	var claims jwt.Claims
+	if err := v.claims(parsed, &claims); err != nil {
+		return Identity{}, err
+	}
+
+	expected := jwt.Expected{Issuer: v.issuer, Time: time.Now()}
+	if v.audience != "" {
+		expected.AnyAudience = jwt.Audience{v.audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, jwtLeeway); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: sub claim is required", ErrInvalidToken)
+	}
+	return Identity{Name: claims.Subject, Method: MethodJWT}, nil
+}
+
+// claims проверяет подпись ключом с kid из заголовка токена, а без kid -
+// всеми ключами набора
+func (v *JWTVerifier) claims(token *jwt.JSONWebToken, claims *jwt.Claims) error {
+	keys := v.keys.Keys
+	if len(token.Headers) > 0 && token.Headers[0].KeyID != "" {
+		keys = v.keys.Key(token.Headers[0].KeyID)
+	}
+	for _, key := range keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if err := token.Claims(key.Public().Key, claims); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature is not valid", ErrInvalidToken)
+}
+
+// Verifiers проверяет токены: JWT - по JWKS, остальные - по списку токенов.
+// Пустое поле отключает соответствующий способ.
+type Verifiers struct {
+	Static *StaticTokens
+	JWT    *JWTVerifier
+}
+
+func (v Verifiers) Verify(ctx context.Context, token string) (Identity, error) {
+	if v.JWT != nil && strings.Count(token, ".") == 2 {
+		return v.JWT.Verify(ctx, token)
+	}
+	if v.Static != nil {
+		return v.Static.Verify(ctx, token)
+	}
+	return Identity{}, ErrInvalidToken
+}
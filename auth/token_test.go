@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// testIssuer выпускает JWT, ключ которого опубликован в JWKS
+type testIssuer struct {
+	key *ecdsa.PrivateKey
+	kid string
+}
+
+func newTestIssuer(t *testing.T, kid string) *testIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testIssuer{key: key, kid: kid}
+}
+
+func (i *testIssuer) sign(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: i.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", i.kid))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func (i *testIssuer) jwks() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &i.key.PublicKey, KeyID: i.kid, Algorithm: string(jose.ES256), Use: "sig"},
+	}}
+}
+
+func writeJSON(t *testing.T, name string, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return writeFile(t, t.TempDir(), name, data)
+}
+
+func TestStaticTokens(t *testing.T) {
+	path := writeJSON(t, "tokens.json", map[string]any{
+		"tokens": []map[string]string{
+			{"token": "s3cr3t", "name": "billing"},
+			{"token": "other", "name": "audit"},
+		},
+	})
+	tokens, err := LoadStaticTokens(path)
+	require.NoError(t, err)
+
+	id, err := tokens.Verify(context.Background(), "s3cr3t")
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Name: "billing", Method: MethodToken}, id)
+
+	_, err = tokens.Verify(context.Background(), "s3cr3t ")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = LoadStaticTokens(writeJSON(t, "bad.json", map[string]any{
+		"tokens": []map[string]string{{"token": "", "name": "billing"}},
+	}))
+	assert.Error(t, err)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	issuer := newTestIssuer(t, "k1")
+	verifier, err := LoadJWKS(writeJSON(t, "jwks.json", issuer.jwks()),
+		JWTOptions{Issuer: "https://idp.example", Audience: "subpub"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	valid := jwt.Claims{
+		Subject:  "billing",
+		Issuer:   "https://idp.example",
+		Audience: jwt.Audience{"subpub"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"Корректный токен", func() string { return issuer.sign(t, valid) }, false},
+		{"Истек срок", func() string {
+			c := valid
+			c.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+			return issuer.sign(t, c)
+		}, true},
+		{"Еще не действует", func() string {
+			c := valid
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+			return issuer.sign(t, c)
+		}, true},
+		{"Чужой издатель", func() string {
+			c := valid
+			c.Issuer = "https://evil.example"
+			return issuer.sign(t, c)
+		}, true},
+		{"Чужой получатель", func() string {
+			c := valid
+			c.Audience = jwt.Audience{"other"}
+			return issuer.sign(t, c)
+		}, true},
+		{"Нет sub", func() string {
+			c := valid
+			c.Subject = ""
+			return issuer.sign(t, c)
+		}, true},
+		{"Подписан неизвестным ключом", func() string {
+			return newTestIssuer(t, "k1").sign(t, valid)
+		}, true},
+		{"Не JWT", func() string { return "a.b.c" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := verifier.Verify(context.Background(), tt.token())
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, Identity{Name: "billing", Method: MethodJWT}, id)
+		})
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t, "k1")
+	jwks, err := LoadJWKS(writeJSON(t, "jwks.json", issuer.jwks()), JWTOptions{})
+	require.NoError(t, err)
+	tokens, err := LoadStaticTokens(writeJSON(t, "tokens.json", map[string]any{
+		"tokens": []map[string]string{{"token": "s3cr3t", "name": "billing"}},
+	}))
+	require.NoError(t, err)
+	a := NewAuthenticator(Verifiers{Static: tokens, JWT: jwks})
+
+	jwtToken := issuer.sign(t, jwt.Claims{Subject: "audit", Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      codes.Code
+		wantID        Identity
+	}{
+		{"Статический токен", "Bearer s3cr3t", codes.OK, Identity{Name: "billing", Method: MethodToken}},
+		{"JWT", "Bearer " + jwtToken, codes.OK, Identity{Name: "audit", Method: MethodJWT}},
+		{"Неизвестный токен", "Bearer wrong", codes.Unauthenticated, Identity{}},
+		{"Другая схема", "Basic czNjcjN0", codes.Unauthenticated, Identity{}},
+		{"Без токена", "", codes.Unauthenticated, Identity{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			var got Identity
+			_, err := a.UnaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+				func(ctx context.Context, _ any) (any, error) {
+					got, _ = FromContext(ctx)
+					return nil, nil
+				})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantID, got)
+
+			// Поток видит ту же идентичность через свой контекст
+			got = Identity{}
+			err = a.StreamInterceptor()(nil, &contextStream{ctx: ctx}, &grpc.StreamServerInfo{},
+				func(_ any, ss grpc.ServerStream) error {
+					got, _ = FromContext(ss.Context())
+					return nil
+				})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantID, got)
+		})
+	}
+}
+
+// TestAuthenticatorMTLS проверяет, что клиент с проверенным сертификатом
+// обходится без токена
+func TestAuthenticatorMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certPEM, _ := ca.issue(t, "billing", x509.ExtKeyUsageClientAuth)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}},
+		}},
+	})
+
+	var got Identity
+	_, err = NewAuthenticator(Verifiers{}).UnaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, _ any) (any, error) {
+			got, _ = FromContext(ctx)
+			return nil, nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "billing", got.Name)
+	assert.Equal(t, MethodTLS, got.Method)
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -2,33 +2,99 @@ package helper
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"log/slog"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-// RespondWithErrorGRPC формирует и возвращает стандартизированный gRPC ответ с ошибкой, логируя информацию.
-func RespondWithErrorGRPC(ctx context.Context, code codes.Code, msg string, err error) error {
+type loggerKey struct{}
+
+// WithLogger сохраняет логгер запроса в контексте
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger возвращает логгер запроса или логгер по умолчанию
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RespondWithErrorGRPC логирует ошибку логгером запроса и возвращает статус gRPC
+// с кодом code, сообщением msg и подробностями details. Ошибки сервера пишутся
+// с уровнем Error, ошибки клиента - с уровнем Warn.
+func RespondWithErrorGRPC(ctx context.Context, code codes.Code, msg string, err error, details ...protoadapt.MessageV1) error {
+	logger := Logger(ctx)
+
+	level := slog.LevelWarn
+	if IsServerError(code) {
+		level = slog.LevelError
+	}
+	attrs := []any{slog.String("code", code.String())}
 	if err != nil {
-		log.Println(err)
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	logger.Log(ctx, level, msg, attrs...)
+
+	st := status.New(code, msg)
+	if len(details) > 0 {
+		withDetails, err := st.WithDetails(details...)
+		if err != nil {
+			logger.Error("failed to attach error details", slog.Any("error", err))
+		} else {
+			st = withDetails
+		}
 	}
+	return st.Err()
+}
 
-	if code > codes.Internal {
-		log.Printf("Responding with 5XX gRPC error: %s", msg)
+// IsServerError сообщает, означает ли код ошибку на стороне сервера,
+// а не некорректный запрос клиента
+func IsServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
 	}
+}
 
-	type errorResponse struct {
-		ServiceError string `json:"error"`
+// BadRequest описывает некорректное поле запроса
+func BadRequest(field, description string) *errdetails.BadRequest {
+	return &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: description},
+		},
 	}
+}
 
-	jsonBytes, err := json.Marshal(errorResponse{ServiceError: msg})
-	if err != nil {
-		log.Printf("Error marshalling error JSON: %s", err)
-		return status.Errorf(codes.Internal, "Failed to marshal error response")
+// ResourceInfo описывает ресурс, к которому относится ошибка
+func ResourceInfo(resourceType, name, description string) *errdetails.ResourceInfo {
+	return &errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: name,
+		Description:  description,
 	}
+}
+
+// RetryInfo сообщает клиенту, через сколько запрос можно повторить
+func RetryInfo(delay time.Duration) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}
+}
 
-	log.Printf("ServiceError: %s, Code: %s", string(jsonBytes), code.String()) // Log the error
-	return status.Error(code, msg)
+// QuotaFailure описывает превышенный лимит
+func QuotaFailure(subject, description string) *errdetails.QuotaFailure {
+	return &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: subject, Description: description},
+		},
+	}
 }
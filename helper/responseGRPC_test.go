@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRespondWithErrorGRPC(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      codes.Code
+		wantLevel string
+	}{
+		{name: "Ошибка клиента", code: codes.InvalidArgument, wantLevel: "level=WARN"},
+		{name: "Превышен лимит", code: codes.ResourceExhausted, wantLevel: "level=WARN"},
+		{name: "Ошибка сервера", code: codes.Internal, wantLevel: "level=ERROR"},
+		{name: "Сервер недоступен", code: codes.Unavailable, wantLevel: "level=ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ctx := WithLogger(context.Background(), slog.New(slog.NewTextHandler(&buf, nil)).With("request_id", "42"))
+
+			err := RespondWithErrorGRPC(ctx, tt.code, "bad key", errors.New("cause"), BadRequest("key", "must not be empty"))
+
+			st := status.Convert(err)
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, "bad key", st.Message())
+			require.Len(t, st.Details(), 1)
+			badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+			require.True(t, ok)
+			assert.Equal(t, "key", badRequest.FieldViolations[0].Field)
+
+			assert.Contains(t, buf.String(), tt.wantLevel)
+			assert.Contains(t, buf.String(), "request_id=42")
+			assert.Contains(t, buf.String(), "error=cause")
+		})
+	}
+}
+
+func TestIsServerError(t *testing.T) {
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		want := code == codes.Unknown || code == codes.DeadlineExceeded || code == codes.Unimplemented ||
+			code == codes.Internal || code == codes.Unavailable || code == codes.DataLoss
+		assert.Equal(t, want, IsServerError(code), code.String())
+	}
+}
@@ -0,0 +1,104 @@
+package limits
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval - как часто из набора удаляются полные корзины: полная
+// корзина ничем не отличается от новой
+const sweepInterval = time.Minute
+
+// Rate - скорость пополнения корзины и ее емкость. Нулевая скорость
+// означает отсутствие ограничения.
+type Rate struct {
+	// PerSecond - сколько запросов в секунду разрешено в среднем
+	PerSecond float64 `json:"per_second"`
+	// Burst - сколько запросов разрешено подряд, по умолчанию - PerSecond,
+	// но не меньше одного
+	Burst int `json:"burst"`
+}
+
+func (r Rate) unlimited() bool {
+	return r.PerSecond <= 0
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(1, math.Ceil(r.PerSecond))
+}
+
+// bucket - корзина токенов одного клиента или темы
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.rate.burst(), b.tokens+elapsed*b.rate.PerSecond)
+		b.last = now
+	}
+}
+
+// take забирает токен или возвращает, через сколько он появится
+func (b *bucket) take(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := (1 - b.tokens) / b.rate.PerSecond
+	return time.Duration(math.Ceil(wait * float64(time.Second))), false
+}
+
+// buckets - корзины по ключу, создаются при первом запросе полными
+type buckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newBuckets() *buckets {
+	return &buckets{buckets: make(map[string]*bucket)}
+}
+
+// take забирает токен из корзины key со скоростью rate
+func (s *buckets) take(key string, rate Rate, now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{rate: rate, tokens: rate.burst(), last: now}
+		s.buckets[key] = b
+	}
+	return b.take(now)
+}
+
+// refund возвращает токен, если запрос все же был отклонен другим лимитом
+func (s *buckets) refund(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(b.rate.burst(), b.tokens+1)
+	}
+}
+
+func (s *buckets) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= b.rate.burst() {
+			delete(s.buckets, key)
+		}
+	}
+}
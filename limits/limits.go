@@ -0,0 +1,211 @@
+// Package limits ограничивает клиентов сервера: частоту публикаций клиента
+// и темы, число одновременных подписок клиента и размер сообщения.
+package limits
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/imhasandl/vk-internship/subpub"
+)
+
+// Лимиты, которые может превысить запрос
+const (
+	LimitClientRate    = "client_rate"
+	LimitSubjectRate   = "subject_rate"
+	LimitSubscriptions = "subscriptions"
+	LimitMessageSize   = "message_size"
+)
+
+// Config - декларативное описание лимитов, нулевые значения не ограничивают:
+//
+//	{
+//	  "max_message_bytes": 1048576,
+//	  "client": {"publish": {"per_second": 100, "burst": 200}, "max_subscriptions": 20},
+//	  "clients": {"billing": {"publish": {"per_second": 1000}}},
+//	  "subjects": [{"subject": "orders.>", "publish": {"per_second": 500}}]
+//	}
+type Config struct {
+	// MaxMessageBytes - наибольший размер данных сообщения
+	MaxMessageBytes int `json:"max_message_bytes"`
+	// Client - лимиты каждого клиента
+	Client ClientLimits `json:"client"`
+	// Clients заменяет лимиты Client для клиентов с этими именами
+	Clients map[string]ClientLimits `json:"clients"`
+	// Subjects - лимиты тем, для темы действует первое совпавшее правило
+	Subjects []SubjectLimits `json:"subjects"`
+}
+
+// ClientLimits - лимиты одного клиента
+type ClientLimits struct {
+	Publish          Rate `json:"publish"`
+	MaxSubscriptions int  `json:"max_subscriptions"`
+}
+
+// SubjectLimits - лимит публикаций в каждую тему, совпадающую с шаблоном
+type SubjectLimits struct {
+	Subject string `json:"subject"`
+	Publish Rate   `json:"publish"`
+}
+
+// Error описывает превышенный лимит. errors.Is(err, subpub.ErrQuotaExceeded)
+// для нее истинно.
+type Error struct {
+	// Limit - какой лимит превышен, одна из констант Limit*
+	Limit string
+	// Key - клиент или тема, к которым относится лимит
+	Key string
+	// RetryAfter - через сколько запрос может пройти, 0 - повтор не поможет
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("limits: %s limit exceeded for %q", e.Limit, e.Key)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == subpub.ErrQuotaExceeded
+}
+
+// Limiter проверяет запросы клиентов по Config
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	clientRates  *buckets
+	subjectRates *buckets
+
+	mu            sync.Mutex
+	subscriptions map[string]int
+
+	rejected map[string]*atomic.Uint64
+}
+
+// Load читает лимиты из JSON файла
+func Load(path string) (*Limiter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("limits: parse %s: %w", path, err)
+	}
+	l, err := New(config)
+	if err != nil {
+		return nil, fmt.Errorf("limits: %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// New проверяет конфигурацию и создает ограничитель
+func New(config Config) (*Limiter, error) {
+	for i, s := range config.Subjects {
+		if err := subpub.ValidatePattern(s.Subject); err != nil {
+			return nil, fmt.Errorf("subject limit %d: %w", i+1, err)
+		}
+	}
+
+	l := &Limiter{
+		config:        config,
+		now:           time.Now,
+		clientRates:   newBuckets(),
+		subjectRates:  newBuckets(),
+		subscriptions: make(map[string]int),
+		rejected:      make(map[string]*atomic.Uint64),
+	}
+	for _, limit := range []string{LimitClientRate, LimitSubjectRate, LimitSubscriptions, LimitMessageSize} {
+		l.rejected[limit] = new(atomic.Uint64)
+	}
+	return l, nil
+}
+
+// client возвращает лимиты клиента
+func (l *Limiter) client(name string) ClientLimits {
+	if limits, ok := l.config.Clients[name]; ok {
+		return limits
+	}
+	return l.config.Client
+}
+
+// subject возвращает лимит темы
+func (l *Limiter) subject(subject string) (SubjectLimits, bool) {
+	for _, s := range l.config.Subjects {
+		if subpub.MatchSubject(s.Subject, subject) {
+			return s, true
+		}
+	}
+	return SubjectLimits{}, false
+}
+
+// AllowPublish проверяет публикацию клиентом client сообщения размером size
+// байт в тему subject и учитывает ее в лимитах частоты
+func (l *Limiter) AllowPublish(client, subject string, size int) error {
+	if limit := l.config.MaxMessageBytes; limit > 0 && size > limit {
+		return l.reject(&Error{Limit: LimitMessageSize, Key: subject})
+	}
+
+	now := l.now()
+	rate := l.client(client).Publish
+	if !rate.unlimited() {
+		if wait, ok := l.clientRates.take(client, rate, now); !ok {
+			return l.reject(&Error{Limit: LimitClientRate, Key: client, RetryAfter: wait})
+		}
+	}
+
+	if s, ok := l.subject(subject); ok && !s.Publish.unlimited() {
+		if wait, ok := l.subjectRates.take(subject, s.Publish, now); !ok {
+			// Отклоненная публикация не расходует лимит клиента
+			if !rate.unlimited() {
+				l.clientRates.refund(client)
+			}
+			return l.reject(&Error{Limit: LimitSubjectRate, Key: subject, RetryAfter: wait})
+		}
+	}
+	return nil
+}
+
+// AcquireSubscription учитывает новую подписку клиента. release нужно
+// вызвать, когда подписка завершится.
+func (l *Limiter) AcquireSubscription(client string) (release func(), err error) {
+	limit := l.client(client).MaxSubscriptions
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subscriptions[client] >= limit {
+		return nil, l.reject(&Error{Limit: LimitSubscriptions, Key: client})
+	}
+	l.subscriptions[client]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.subscriptions[client]--; l.subscriptions[client] == 0 {
+				delete(l.subscriptions, client)
+			}
+		})
+	}, nil
+}
+
+func (l *Limiter) reject(err *Error) error {
+	l.rejected[err.Limit].Add(1)
+	return err
+}
+
+// Rejected возвращает число отклоненных запросов по каждому лимиту
+func (l *Limiter) Rejected() map[string]uint64 {
+	counts := make(map[string]uint64, len(l.rejected))
+	for limit, n := range l.rejected {
+		counts[limit] = n.Load()
+	}
+	return counts
+}
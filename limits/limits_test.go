@@ -0,0 +1,146 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock - часы, которые двигает тест
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(t *testing.T, config Config) (*Limiter, *fakeClock) {
+	t.Helper()
+	l, err := New(config)
+	require.NoError(t, err)
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestClientRate(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{
+		Client:  ClientLimits{Publish: Rate{PerSecond: 2, Burst: 3}},
+		Clients: map[string]ClientLimits{"billing": {}},
+	})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.AllowPublish("audit", "orders", 1), "Запрос %d в пределах burst", i+1)
+	}
+	err := l.AllowPublish("audit", "orders", 1)
+	var limitErr *Error
+	require.ErrorAs(t, err, &limitErr)
+	assert.ErrorIs(t, err, subpub.ErrQuotaExceeded)
+	assert.Equal(t, LimitClientRate, limitErr.Limit)
+	assert.Equal(t, "audit", limitErr.Key)
+	assert.Equal(t, 500*time.Millisecond, limitErr.RetryAfter)
+
+	// Лимит одного клиента не мешает другим
+	assert.NoError(t, l.AllowPublish("other", "orders", 1))
+	// Для billing лимиты заменены пустыми
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.AllowPublish("billing", "orders", 1))
+	}
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.NoError(t, l.AllowPublish("audit", "orders", 1))
+	assert.Error(t, l.AllowPublish("audit", "orders", 1))
+
+	assert.Equal(t, uint64(2), l.Rejected()[LimitClientRate])
+}
+
+func TestSubjectRate(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{
+		Client: ClientLimits{Publish: Rate{PerSecond: 10}},
+		Subjects: []SubjectLimits{
+			{Subject: "orders.internal", Publish: Rate{}},
+			{Subject: "orders.*", Publish: Rate{PerSecond: 1}},
+		},
+	})
+
+	require.NoError(t, l.AllowPublish("a", "orders.eu", 1))
+	err := l.AllowPublish("b", "orders.eu", 1)
+	var limitErr *Error
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, LimitSubjectRate, limitErr.Limit)
+	assert.Equal(t, time.Second, limitErr.RetryAfter)
+
+	// Каждая тема шаблона получает свой лимит, первое правило снимает лимит
+	assert.NoError(t, l.AllowPublish("b", "orders.us", 1))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.AllowPublish("c", "orders.internal", 1))
+	}
+
+	// Отклоненная по теме публикация не тратит лимит клиента
+	for i := 0; i < 5; i++ {
+		assert.Error(t, l.AllowPublish("d", "orders.eu", 1))
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.AllowPublish("d", "payments", 1))
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	assert.NoError(t, l.AllowPublish("b", "orders.eu", 1))
+}
+
+func TestMessageSize(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{MaxMessageBytes: 4})
+
+	assert.NoError(t, l.AllowPublish("a", "orders", 4))
+	err := l.AllowPublish("a", "orders", 5)
+	var limitErr *Error
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, LimitMessageSize, limitErr.Limit)
+	assert.Zero(t, limitErr.RetryAfter)
+}
+
+func TestSubscriptions(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{Client: ClientLimits{MaxSubscriptions: 2}})
+
+	first, err := l.AcquireSubscription("a")
+	require.NoError(t, err)
+	_, err = l.AcquireSubscription("a")
+	require.NoError(t, err)
+	_, err = l.AcquireSubscription("a")
+	assert.True(t, errors.Is(err, subpub.ErrQuotaExceeded))
+	_, err = l.AcquireSubscription("b")
+	assert.NoError(t, err)
+
+	// Повторный release не освобождает чужую подписку
+	first()
+	first()
+	_, err = l.AcquireSubscription("a")
+	require.NoError(t, err)
+	_, err = l.AcquireSubscription("a")
+	assert.Error(t, err)
+	assert.Equal(t, uint64(2), l.Rejected()[LimitSubscriptions])
+}
+
+func TestBucketSweep(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{Client: ClientLimits{Publish: Rate{PerSecond: 1}}})
+
+	for _, client := range []string{"a", "b", "c"} {
+		require.NoError(t, l.AllowPublish(client, "orders", 1))
+	}
+	assert.Len(t, l.clientRates.buckets, 3)
+
+	// Полные корзины удаляются, а занятая остается
+	clock.now = clock.now.Add(2 * sweepInterval)
+	require.NoError(t, l.AllowPublish("a", "orders", 1))
+	assert.Len(t, l.clientRates.buckets, 1)
+}
+
+func TestNewInvalidSubject(t *testing.T) {
+	_, err := New(Config{Subjects: []SubjectLimits{{Subject: "orders.>.eu"}}})
+	assert.ErrorIs(t, err, subpub.ErrInvalidSubject)
+}
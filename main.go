@@ -1,15 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/imhasandl/vk-internship/auth"
+	"github.com/imhasandl/vk-internship/limits"
+	"github.com/imhasandl/vk-internship/metrics"
 	pb "github.com/imhasandl/vk-internship/protos"
 	"github.com/imhasandl/vk-internship/server"
+	"github.com/imhasandl/vk-internship/store"
 	"github.com/imhasandl/vk-internship/subpub"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
@@ -17,26 +38,370 @@ func main() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	logger, err := newLogger()
+	if err != nil {
+		log.Fatalf("invalid log config: %v", err)
+	}
+	// Стандартный log тоже пишет через slog
+	slog.SetDefault(logger)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatalf("Set server port in env")
 	}
 
-	pubSub := subpub.NewSubPub()
+	opts := []subpub.Option{subpub.WithLogger(logger)}
+	if dataDir := os.Getenv("DATA_DIR"); dataDir != "" {
+		storeOpts, err := storeOptions()
+		if err != nil {
+			log.Fatalf("invalid message log config: %v", err)
+		}
+		st, err := store.Open(dataDir, storeOpts)
+		if err != nil {
+			log.Fatalf("failed to open message log: %v", err)
+		}
+		defer st.Close()
+		if n := st.TruncatedBytes(); n > 0 {
+			logger.Warn("message log recovered, truncated torn records", slog.Int64("bytes", n))
+		}
+		opts = append(opts, subpub.WithStore(st))
+	}
+
+	if workers := os.Getenv("SUBSCRIBER_WORKERS"); workers != "" {
+		n, err := strconv.Atoi(workers)
+		if err != nil {
+			log.Fatalf("invalid broker config: SUBSCRIBER_WORKERS: %v", err)
+		}
+		opts = append(opts, subpub.WithWorkers(n))
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	brokerMetrics := metrics.NewPrometheus(registry)
+	opts = append(opts, subpub.WithMetrics(brokerMetrics))
+
+	tp, err := tracerProvider()
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	if tp != nil {
+		defer tp.Shutdown(context.Background())
+		opts = append(opts, subpub.WithTracerProvider(tp))
+	}
+
+	pubSub := subpub.NewSubPub(opts...)
+	brokerMetrics.WatchQueues(pubSub)
 
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listed: %v", err)
 	}
 
-	server := server.NewServer(port, pubSub)
+	serverOpts, err := serverOptions()
+	if err != nil {
+		log.Fatalf("invalid server config: %v", err)
+	}
+
+	authenticator, err := tokenAuthenticator()
+	if err != nil {
+		log.Fatalf("invalid auth config: %v", err)
+	}
+	if path := os.Getenv("ACL_FILE"); path != "" {
+		if authenticator == nil && os.Getenv("TLS_CLIENT_CA_FILE") == "" {
+			log.Fatalf("invalid auth config: ACL_FILE requires AUTH_TOKENS_FILE, AUTH_JWKS_FILE or TLS_CLIENT_CA_FILE")
+		}
+		policy, err := auth.LoadPolicy(path)
+		if err != nil {
+			log.Fatalf("invalid auth config: %v", err)
+		}
+		serverOpts = append(serverOpts, server.WithAuthorizer(policy))
+	}
+
+	if path := os.Getenv("LIMITS_FILE"); path != "" {
+		limiter, err := limits.Load(path)
+		if err != nil {
+			log.Fatalf("invalid limits config: %v", err)
+		}
+		brokerMetrics.WatchLimits(limiter)
+		serverOpts = append(serverOpts, server.WithLimiter(limiter))
+	}
+
+	serverOpts = append(serverOpts, server.WithLogger(logger))
+	if tp != nil {
+		serverOpts = append(serverOpts, server.WithTracerProvider(tp))
+	}
+	server := server.NewServer(port, pubSub, serverOpts...)
 	
-	s := grpc.NewServer()
+	grpcMetrics := grpcprom.NewServerMetrics(grpcprom.WithServerHandlingTimeHistogram())
+	registry.MustRegister(grpcMetrics)
+
+	grpcOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcMetrics.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(grpcMetrics.StreamServerInterceptor()),
+	}
+	if authenticator != nil {
+		grpcOpts = append(grpcOpts,
+			grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+			grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+		)
+	}
+	creds, err := transportCredentials(logger)
+	if err != nil {
+		log.Fatalf("invalid TLS config: %v", err)
+	}
+	if creds != nil {
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+	} else {
+		logger.Warn("TLS is not configured, serving plaintext gRPC")
+	}
+
+	s := grpc.NewServer(grpcOpts...)
 	pb.RegisterSubPubServer(s, server)
+	grpcMetrics.InitializeMetrics(s)
+
+	var metricsServer *http.Server
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsServer = serveMetrics(addr, registry)
+	}
+
+	shutdownTimeout, err := durationEnv("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		log.Fatalf("invalid server config: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("server listening", slog.String("addr", lis.Addr().String()))
 
-	log.Printf("Server listening on %v", lis.Addr())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(lis)
+	}()
 
-	if err := s.Serve(lis); err != nil {
+	select {
+	case err := <-serveErr:
 		log.Fatalf("failed to serve: %v", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", slog.Duration("timeout", shutdownTimeout))
+	shutdown(s, server, shutdownTimeout)
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+}
+
+// tracerProvider создает экспорт трасс по OTLP, если задан
+// OTEL_EXPORTER_OTLP_ENDPOINT. Остальные настройки экспорта и имя сервиса
+// берутся из стандартных переменных OTEL_*.
+func tracerProvider() (*sdktrace.TracerProvider, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil, nil
+	}
+	exporter, err := otlptracegrpc.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+// transportCredentials включает TLS, если заданы TLS_CERT_FILE и TLS_KEY_FILE,
+// и mTLS, если задан TLS_CLIENT_CA_FILE
+func transportCredentials(logger *slog.Logger) (credentials.TransportCredentials, error) {
+	opts := auth.TLSOptions{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		Logger:       logger,
+	}
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		if opts.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	var err error
+	if opts.ClientCAFile != "" {
+		opts.ClientAuth, err = auth.ParseClientAuth(os.Getenv("TLS_CLIENT_AUTH"))
+		if err != nil {
+			return nil, fmt.Errorf("TLS_CLIENT_AUTH: %w", err)
+		}
+	}
+	opts.ReloadInterval, err = durationEnv("TLS_RELOAD_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := auth.NewTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// tokenAuthenticator включает проверку bearer токенов, если задан
+// AUTH_TOKENS_FILE или AUTH_JWKS_FILE
+func tokenAuthenticator() (*auth.Authenticator, error) {
+	var verifiers auth.Verifiers
+	if path := os.Getenv("AUTH_TOKENS_FILE"); path != "" {
+		tokens, err := auth.LoadStaticTokens(path)
+		if err != nil {
+			return nil, err
+		}
+		verifiers.Static = tokens
+	}
+	if path := os.Getenv("AUTH_JWKS_FILE"); path != "" {
+		jwks, err := auth.LoadJWKS(path, auth.JWTOptions{
+			Issuer:   os.Getenv("AUTH_JWT_ISSUER"),
+			Audience: os.Getenv("AUTH_JWT_AUDIENCE"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		verifiers.JWT = jwks
+	}
+	if verifiers.Static == nil && verifiers.JWT == nil {
+		return nil, nil
+	}
+	return auth.NewAuthenticator(verifiers), nil
+}
+
+// serveMetrics отдает метрики Prometheus по HTTP на /metrics
+func serveMetrics(addr string, registry *prometheus.Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		slog.Info("metrics listening", slog.String("addr", addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to serve metrics: %v", err)
+		}
+	}()
+	return srv
+}
+
+// defaultShutdownTimeout - сколько ждать дообработки сообщений при остановке
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdown останавливает сервер: перестает принимать новые RPC, отклоняет
+// публикации, дает подписчикам дообработать очереди и завершает их потоки.
+// Если к дедлайну не все RPC завершились, соединения закрываются принудительно.
+func shutdown(s *grpc.Server, srv interface{ Shutdown(context.Context) error }, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("subscribers were not drained", slog.Any("error", err))
+	}
+
+	select {
+	case <-stopped:
+		slog.Info("server stopped")
+	case <-ctx.Done():
+		slog.Warn("shutdown deadline exceeded, closing remaining connections")
+		s.Stop()
+		<-stopped
+	}
+}
+
+// storeOptions читает настройки журнала из окружения
+func storeOptions() (store.Options, error) {
+	policy, err := store.ParseSyncPolicy(os.Getenv("WAL_SYNC"))
+	if err != nil {
+		return store.Options{}, err
+	}
+	opts := store.Options{Sync: policy}
+
+	if interval := os.Getenv("WAL_SYNC_INTERVAL"); interval != "" {
+		opts.SyncInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			return store.Options{}, fmt.Errorf("WAL_SYNC_INTERVAL: %w", err)
+		}
+	}
+	if maxOpen := os.Getenv("WAL_MAX_OPEN_FILES"); maxOpen != "" {
+		opts.MaxOpenFiles, err = strconv.Atoi(maxOpen)
+		if err != nil {
+			return store.Options{}, fmt.Errorf("WAL_MAX_OPEN_FILES: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// newLogger создает логгер по LOG_LEVEL (debug, info, warn, error)
+// и LOG_FORMAT (json или text)
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "json", "":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT: unknown format %q", format)
+	}
+}
+
+// durationEnv читает длительность из окружения
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// serverOptions читает настройки подтверждений из окружения
+func serverOptions() ([]server.Option, error) {
+	var opts []server.Option
+
+	if ackWait := os.Getenv("ACK_WAIT"); ackWait != "" {
+		d, err := time.ParseDuration(ackWait)
+		if err != nil {
+			return nil, fmt.Errorf("ACK_WAIT: %w", err)
+		}
+		opts = append(opts, server.WithAckWait(d))
+	}
+
+	if maxDeliver := os.Getenv("MAX_DELIVER"); maxDeliver != "" {
+		n, err := strconv.ParseUint(maxDeliver, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_DELIVER: %w", err)
+		}
+		opts = append(opts, server.WithMaxDeliver(uint32(n)))
 	}
+
+	if maxPending := os.Getenv("MAX_ACK_PENDING"); maxPending != "" {
+		n, err := strconv.ParseUint(maxPending, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_ACK_PENDING: %w", err)
+		}
+		opts = append(opts, server.WithMaxAckPending(uint32(n)))
+	}
+
+	return opts, nil
 }
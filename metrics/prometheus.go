@@ -0,0 +1,131 @@
+// Package metrics собирает метрики брокера в Prometheus.
+package metrics
+
+import (
+	"time"
+
+	"github.com/imhasandl/vk-internship/limits"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "subpub"
+
+// Prometheus реализует subpub.Metrics поверх счетчиков Prometheus
+type Prometheus struct {
+	published      *prometheus.CounterVec
+	delivered      *prometheus.CounterVec
+	dropped        *prometheus.CounterVec
+	subscriptions  *prometheus.GaugeVec
+	handlerSeconds *prometheus.HistogramVec
+	reg            prometheus.Registerer
+}
+
+// NewPrometheus создает метрики брокера и регистрирует их в reg
+func NewPrometheus(reg prometheus.Registerer) *Prometheus {
+	m := &Prometheus{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_total",
+			Help:      "Сообщения, опубликованные в тему.",
+		}, []string{"subject"}),
+		delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "delivered_total",
+			Help:      "Сообщения темы, обработанные подписчиками.",
+		}, []string{"subject"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dropped_total",
+			Help:      "Сообщения темы, отброшенные из-за переполнения очередей подписчиков.",
+		}, []string{"subject"}),
+		subscriptions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_subscriptions",
+			Help:      "Активные подписки по шаблону темы.",
+		}, []string{"pattern"}),
+		handlerSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Время обработки сообщения обработчиком подписчика.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"subject"}),
+		reg: reg,
+	}
+	reg.MustRegister(m.published, m.delivered, m.dropped, m.subscriptions, m.handlerSeconds)
+	return m
+}
+
+func (m *Prometheus) MessagePublished(subject string) {
+	m.published.WithLabelValues(subject).Inc()
+}
+
+func (m *Prometheus) MessageDelivered(subject string, d time.Duration) {
+	m.delivered.WithLabelValues(subject).Inc()
+	m.handlerSeconds.WithLabelValues(subject).Observe(d.Seconds())
+}
+
+func (m *Prometheus) MessageDropped(subject string) {
+	m.dropped.WithLabelValues(subject).Inc()
+}
+
+func (m *Prometheus) SubscriptionStarted(pattern string) {
+	m.subscriptions.WithLabelValues(pattern).Inc()
+}
+
+func (m *Prometheus) SubscriptionEnded(pattern string) {
+	m.subscriptions.WithLabelValues(pattern).Dec()
+}
+
+// WatchQueues регистрирует глубину очередей подписчиков брокера ps.
+// Значение снимается в момент сбора метрик.
+func (m *Prometheus) WatchQueues(ps *subpub.PubSub) {
+	m.reg.MustRegister(&queueCollector{ps: ps})
+}
+
+var queueDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "queue_depth"),
+	"Сообщения, ожидающие обработки в очередях подписчиков, по шаблону темы.",
+	[]string{"pattern"}, nil,
+)
+
+// queueCollector снимает глубину очередей с брокера при каждом сборе
+type queueCollector struct {
+	ps *subpub.PubSub
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for pattern, depth := range c.ps.QueueDepths() {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), pattern)
+	}
+}
+
+// WatchLimits регистрирует число запросов, отклоненных лимитами l
+func (m *Prometheus) WatchLimits(l *limits.Limiter) {
+	m.reg.MustRegister(&limitCollector{limiter: l})
+}
+
+var limitRejectedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "limit_rejected_total"),
+	"Запросы, отклоненные из-за превышения лимита, по виду лимита.",
+	[]string{"limit"}, nil,
+)
+
+// limitCollector снимает счетчики отказов с ограничителя при каждом сборе
+type limitCollector struct {
+	limiter *limits.Limiter
+}
+
+func (c *limitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- limitRejectedDesc
+}
+
+func (c *limitCollector) Collect(ch chan<- prometheus.Metric) {
+	for limit, n := range c.limiter.Rejected() {
+		ch <- prometheus.MustNewConstMetric(limitRejectedDesc, prometheus.CounterValue, float64(n), limit)
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/imhasandl/vk-internship/limits"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewPrometheus(reg)
+	pubSub := subpub.NewSubPub(subpub.WithMetrics(m))
+	m.WatchQueues(pubSub)
+
+	sub, err := pubSub.Subscribe("orders.*", func(msg interface{}) {})
+	require.NoError(t, err)
+	require.NoError(t, pubSub.Publish("orders.eu", "1"))
+	require.NoError(t, pubSub.Publish("orders.eu", "2"))
+	require.NoError(t, pubSub.Publish("orders.us", "3"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.subscriptions.WithLabelValues("orders.*")))
+	assert.NoError(t, testutil.CollectAndCompare(m.published, strings.NewReader(`
+# HELP subpub_published_total Сообщения, опубликованные в тему.
+# TYPE subpub_published_total counter
+subpub_published_total{subject="orders.eu"} 2
+subpub_published_total{subject="orders.us"} 1
+`)))
+
+	sub.Unsubscribe()
+	<-sub.Done()
+	require.NoError(t, pubSub.Close(context.Background()))
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.subscriptions.WithLabelValues("orders.*")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.handlerSeconds), "Гистограмма на каждую тему")
+
+	names := make(map[string]bool)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"subpub_published_total",
+		"subpub_active_subscriptions",
+		"subpub_handler_duration_seconds",
+	} {
+		assert.True(t, names[name], name)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewPrometheus(reg)
+	pubSub := subpub.NewSubPub(subpub.WithMetrics(m))
+	m.WatchQueues(pubSub)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, err := pubSub.Subscribe("jobs", func(msg interface{}) {
+		if msg == "1" {
+			close(started)
+		}
+		<-release
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, pubSub.Publish("jobs", "1"))
+	<-started
+	require.NoError(t, pubSub.Publish("jobs", "2"))
+	require.NoError(t, pubSub.Publish("jobs", "3"))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP subpub_queue_depth Сообщения, ожидающие обработки в очередях подписчиков, по шаблону темы.
+# TYPE subpub_queue_depth gauge
+subpub_queue_depth{pattern="jobs"} 2
+`), "subpub_queue_depth"))
+
+	close(release)
+	require.NoError(t, pubSub.Close(context.Background()))
+}
+
+func TestLimitRejected(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewPrometheus(reg)
+	limiter, err := limits.New(limits.Config{MaxMessageBytes: 1})
+	require.NoError(t, err)
+	m.WatchLimits(limiter)
+
+	require.NoError(t, limiter.AllowPublish("billing", "orders", 1))
+	require.Error(t, limiter.AllowPublish("billing", "orders", 2))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP subpub_limit_rejected_total Запросы, отклоненные из-за превышения лимита, по виду лимита.
+# TYPE subpub_limit_rejected_total counter
+subpub_limit_rejected_total{limit="client_rate"} 0
+subpub_limit_rejected_total{limit="message_size"} 1
+subpub_limit_rejected_total{limit="subject_rate"} 0
+subpub_limit_rejected_total{limit="subscriptions"} 0
+`), "subpub_limit_rejected_total"))
+}
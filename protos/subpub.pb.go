@@ -9,7 +9,9 @@ package protos
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -22,9 +24,124 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Позиция журнала, с которой подписчик начинает получать сообщения
+type StartPosition int32
+
+const (
+	StartPosition_START_POSITION_LATEST   StartPosition = 0 // только новые сообщения
+	StartPosition_START_POSITION_EARLIEST StartPosition = 1 // вся история из журнала
+	StartPosition_START_POSITION_SEQUENCE StartPosition = 2 // с сообщения с номером start_sequence
+	StartPosition_START_POSITION_TIME     StartPosition = 3 // с сообщений, опубликованных не раньше start_time
+)
+
+// Enum value maps for StartPosition.
+var (
+	StartPosition_name = map[int32]string{
+		0: "START_POSITION_LATEST",
+		1: "START_POSITION_EARLIEST",
+		2: "START_POSITION_SEQUENCE",
+		3: "START_POSITION_TIME",
+	}
+	StartPosition_value = map[string]int32{
+		"START_POSITION_LATEST":   0,
+		"START_POSITION_EARLIEST": 1,
+		"START_POSITION_SEQUENCE": 2,
+		"START_POSITION_TIME":     3,
+	}
+)
+
+func (x StartPosition) Enum() *StartPosition {
+	p := new(StartPosition)
+	*p = x
+	return p
+}
+
+func (x StartPosition) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StartPosition) Descriptor() protoreflect.EnumDescriptor {
+	return file_subpub_proto_enumTypes[0].Descriptor()
+}
+
+func (StartPosition) Type() protoreflect.EnumType {
+	return &file_subpub_proto_enumTypes[0]
+}
+
+func (x StartPosition) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StartPosition.Descriptor instead.
+func (StartPosition) EnumDescriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{0}
+}
+
+// Что делать, если подписчик не успевает разбирать свою очередь. Ждать
+// подписчика нельзя: медленный клиент остановил бы все публикации в тему.
+// Consume принимает только DEFAULT и DISCONNECT: вытесненное из очереди
+// событие никогда не было бы доставлено повторно.
+type OverflowPolicy int32
+
+const (
+	OverflowPolicy_OVERFLOW_POLICY_DEFAULT     OverflowPolicy = 0 // DROP_OLDEST для Subscribe, DISCONNECT для Consume
+	OverflowPolicy_OVERFLOW_POLICY_DROP_OLDEST OverflowPolicy = 1 // вытеснять самое старое сообщение очереди
+	OverflowPolicy_OVERFLOW_POLICY_DROP_NEWEST OverflowPolicy = 2 // отбрасывать новое сообщение
+	OverflowPolicy_OVERFLOW_POLICY_DISCONNECT  OverflowPolicy = 3 // завершить подписку с ResourceExhausted
+)
+
+// Enum value maps for OverflowPolicy.
+var (
+	OverflowPolicy_name = map[int32]string{
+		0: "OVERFLOW_POLICY_DEFAULT",
+		1: "OVERFLOW_POLICY_DROP_OLDEST",
+		2: "OVERFLOW_POLICY_DROP_NEWEST",
+		3: "OVERFLOW_POLICY_DISCONNECT",
+	}
+	OverflowPolicy_value = map[string]int32{
+		"OVERFLOW_POLICY_DEFAULT":     0,
+		"OVERFLOW_POLICY_DROP_OLDEST": 1,
+		"OVERFLOW_POLICY_DROP_NEWEST": 2,
+		"OVERFLOW_POLICY_DISCONNECT":  3,
+	}
+)
+
+func (x OverflowPolicy) Enum() *OverflowPolicy {
+	p := new(OverflowPolicy)
+	*p = x
+	return p
+}
+
+func (x OverflowPolicy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (OverflowPolicy) Descriptor() protoreflect.EnumDescriptor {
+	return file_subpub_proto_enumTypes[1].Descriptor()
+}
+
+func (OverflowPolicy) Type() protoreflect.EnumType {
+	return &file_subpub_proto_enumTypes[1]
+}
+
+func (x OverflowPolicy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use OverflowPolicy.Descriptor instead.
+func (OverflowPolicy) EnumDescriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{1}
+}
+
 type SubscribeRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Имя очереди: подписчики одной очереди делят между собой поток сообщений
+	Group         string                 `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	Start         StartPosition          `protobuf:"varint,3,opt,name=start,proto3,enum=subpub.StartPosition" json:"start,omitempty"`
+	StartSequence uint64                 `protobuf:"varint,4,opt,name=start_sequence,json=startSequence,proto3" json:"start_sequence,omitempty"`
+	StartTime     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	Overflow      OverflowPolicy         `protobuf:"varint,6,opt,name=overflow,proto3,enum=subpub.OverflowPolicy" json:"overflow,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -66,10 +183,53 @@ func (x *SubscribeRequest) GetKey() string {
 	return ""
 }
 
+func (x *SubscribeRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetStart() StartPosition {
+	if x != nil {
+		return x.Start
+	}
+	return StartPosition_START_POSITION_LATEST
+}
+
+func (x *SubscribeRequest) GetStartSequence() uint64 {
+	if x != nil {
+		return x.StartSequence
+	}
+	return 0
+}
+
+func (x *SubscribeRequest) GetStartTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetOverflow() OverflowPolicy {
+	if x != nil {
+		return x.Overflow
+	}
+	return OverflowPolicy_OVERFLOW_POLICY_DEFAULT
+}
+
 type PublishRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Data          string                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Текстовые данные. Если задан payload, поле игнорируется
+	Data string `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Произвольные бинарные данные
+	Payload []byte            `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	Headers map[string]string `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// MIME тип данных, например application/json
+	ContentType string `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// Сохранить сообщение как последнее значение темы для новых подписчиков
+	Retain        bool `protobuf:"varint,6,opt,name=retain,proto3" json:"retain,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -118,16 +278,203 @@ func (x *PublishRequest) GetData() string {
 	return ""
 }
 
-type Event struct {
+func (x *PublishRequest) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetRetain() bool {
+	if x != nil {
+		return x.Retain
+	}
+	return false
+}
+
+type PublishBatchRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          string                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Messages      []*PublishRequest      `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishBatchRequest) Reset() {
+	*x = PublishBatchRequest{}
+	mi := &file_subpub_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishBatchRequest) ProtoMessage() {}
+
+func (x *PublishBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishBatchRequest.ProtoReflect.Descriptor instead.
+func (*PublishBatchRequest) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PublishBatchRequest) GetMessages() []*PublishRequest {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+// Результат публикации одного сообщения
+type PublishResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Код статуса gRPC, 0 (OK) - сообщение опубликовано
+	Code          int32  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishResult) Reset() {
+	*x = PublishResult{}
+	mi := &file_subpub_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishResult) ProtoMessage() {}
+
+func (x *PublishResult) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishResult.ProtoReflect.Descriptor instead.
+func (*PublishResult) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PublishResult) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *PublishResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type PublishBatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Результаты в порядке сообщений запроса
+	Results       []*PublishResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishBatchResponse) Reset() {
+	*x = PublishBatchResponse{}
+	mi := &file_subpub_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishBatchResponse) ProtoMessage() {}
+
+func (x *PublishBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishBatchResponse.ProtoReflect.Descriptor instead.
+func (*PublishBatchResponse) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PublishBatchResponse) GetResults() []*PublishResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type Event struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Данные в виде строки, если они являются корректным UTF-8
+	Data string `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// Сквозной номер сообщения, по нему можно продолжить чтение журнала
+	Sequence uint64 `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Тема, в которую было опубликовано сообщение
+	Key string `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	// Номер попытки доставки, для Consume начинается с 1
+	Delivery uint32 `protobuf:"varint,4,opt,name=delivery,proto3" json:"delivery,omitempty"`
+	// Данные сообщения в исходном виде
+	Payload     []byte            `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
+	Headers     map[string]string `protobuf:"bytes,6,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ContentType string            `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// Уникальный идентификатор, назначенный сервером при публикации
+	Id          string                 `protobuf:"bytes,8,opt,name=id,proto3" json:"id,omitempty"`
+	PublishedAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=published_at,json=publishedAt,proto3" json:"published_at,omitempty"`
+	// Событие - сохраненное значение темы, отправленное при подписке
+	Retained      bool `protobuf:"varint,10,opt,name=retained,proto3" json:"retained,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Event) Reset() {
 	*x = Event{}
-	mi := &file_subpub_proto_msgTypes[2]
+	mi := &file_subpub_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -139,7 +486,7 @@ func (x *Event) String() string {
 func (*Event) ProtoMessage() {}
 
 func (x *Event) ProtoReflect() protoreflect.Message {
-	mi := &file_subpub_proto_msgTypes[2]
+	mi := &file_subpub_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -152,7 +499,7 @@ func (x *Event) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Event.ProtoReflect.Descriptor instead.
 func (*Event) Descriptor() ([]byte, []int) {
-	return file_subpub_proto_rawDescGZIP(), []int{2}
+	return file_subpub_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Event) GetData() string {
@@ -162,21 +509,551 @@ func (x *Event) GetData() string {
 	return ""
 }
 
+func (x *Event) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Event) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Event) GetDelivery() uint32 {
+	if x != nil {
+		return x.Delivery
+	}
+	return 0
+}
+
+func (x *Event) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Event) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Event) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Event) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Event) GetPublishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.PublishedAt
+	}
+	return nil
+}
+
+func (x *Event) GetRetained() bool {
+	if x != nil {
+		return x.Retained
+	}
+	return false
+}
+
+type ConsumeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Request:
+	//
+	//	*ConsumeRequest_Start
+	//	*ConsumeRequest_Ack
+	Request       isConsumeRequest_Request `protobuf_oneof:"request"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumeRequest) Reset() {
+	*x = ConsumeRequest{}
+	mi := &file_subpub_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeRequest) ProtoMessage() {}
+
+func (x *ConsumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeRequest.ProtoReflect.Descriptor instead.
+func (*ConsumeRequest) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ConsumeRequest) GetRequest() isConsumeRequest_Request {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *ConsumeRequest) GetStart() *ConsumeStart {
+	if x != nil {
+		if x, ok := x.Request.(*ConsumeRequest_Start); ok {
+			return x.Start
+		}
+	}
+	return nil
+}
+
+func (x *ConsumeRequest) GetAck() *Ack {
+	if x != nil {
+		if x, ok := x.Request.(*ConsumeRequest_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+type isConsumeRequest_Request interface {
+	isConsumeRequest_Request()
+}
+
+type ConsumeRequest_Start struct {
+	Start *ConsumeStart `protobuf:"bytes,1,opt,name=start,proto3,oneof"`
+}
+
+type ConsumeRequest_Ack struct {
+	Ack *Ack `protobuf:"bytes,2,opt,name=ack,proto3,oneof"`
+}
+
+func (*ConsumeRequest_Start) isConsumeRequest_Request() {}
+
+func (*ConsumeRequest_Ack) isConsumeRequest_Request() {}
+
+type ConsumeStart struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Subscription *SubscribeRequest      `protobuf:"bytes,1,opt,name=subscription,proto3" json:"subscription,omitempty"`
+	// Через сколько неподтвержденное событие доставляется повторно
+	AckWait *durationpb.Duration `protobuf:"bytes,2,opt,name=ack_wait,json=ackWait,proto3" json:"ack_wait,omitempty"`
+	// Сколько раз доставлять событие, прежде чем отправить его в $DLQ.<key>
+	MaxDeliver uint32 `protobuf:"varint,3,opt,name=max_deliver,json=maxDeliver,proto3" json:"max_deliver,omitempty"`
+	// Сколько событий может ждать подтверждения. Пока лимит достигнут,
+	// новые события не отправляются
+	MaxAckPending uint32 `protobuf:"varint,4,opt,name=max_ack_pending,json=maxAckPending,proto3" json:"max_ack_pending,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumeStart) Reset() {
+	*x = ConsumeStart{}
+	mi := &file_subpub_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeStart) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeStart) ProtoMessage() {}
+
+func (x *ConsumeStart) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeStart.ProtoReflect.Descriptor instead.
+func (*ConsumeStart) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ConsumeStart) GetSubscription() *SubscribeRequest {
+	if x != nil {
+		return x.Subscription
+	}
+	return nil
+}
+
+func (x *ConsumeStart) GetAckWait() *durationpb.Duration {
+	if x != nil {
+		return x.AckWait
+	}
+	return nil
+}
+
+func (x *ConsumeStart) GetMaxDeliver() uint32 {
+	if x != nil {
+		return x.MaxDeliver
+	}
+	return 0
+}
+
+func (x *ConsumeStart) GetMaxAckPending() uint32 {
+	if x != nil {
+		return x.MaxAckPending
+	}
+	return 0
+}
+
+type Ack struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequences     []uint64               `protobuf:"varint,1,rep,packed,name=sequences,proto3" json:"sequences,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_subpub_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Ack) GetSequences() []uint64 {
+	if x != nil {
+		return x.Sequences
+	}
+	return nil
+}
+
+type ClearRetainedRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Тема или шаблон тем
+	Key           string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearRetainedRequest) Reset() {
+	*x = ClearRetainedRequest{}
+	mi := &file_subpub_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearRetainedRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearRetainedRequest) ProtoMessage() {}
+
+func (x *ClearRetainedRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearRetainedRequest.ProtoReflect.Descriptor instead.
+func (*ClearRetainedRequest) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ClearRetainedRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type ClearRetainedResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Число удаленных значений
+	Cleared       uint32 `protobuf:"varint,1,opt,name=cleared,proto3" json:"cleared,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearRetainedResponse) Reset() {
+	*x = ClearRetainedResponse{}
+	mi := &file_subpub_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearRetainedResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearRetainedResponse) ProtoMessage() {}
+
+func (x *ClearRetainedResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearRetainedResponse.ProtoReflect.Descriptor instead.
+func (*ClearRetainedResponse) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ClearRetainedResponse) GetCleared() uint32 {
+	if x != nil {
+		return x.Cleared
+	}
+	return 0
+}
+
+type RequestRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Message *PublishRequest        `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	// Сколько ждать ответов, по умолчанию 5 секунд
+	Timeout *durationpb.Duration `protobuf:"bytes,2,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	// Сколько ответов собрать: 0 или 1 - первый ответ, N - до N ответов
+	// до истечения timeout
+	MaxReplies    uint32 `protobuf:"varint,3,opt,name=max_replies,json=maxReplies,proto3" json:"max_replies,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestRequest) Reset() {
+	*x = RequestRequest{}
+	mi := &file_subpub_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestRequest) ProtoMessage() {}
+
+func (x *RequestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestRequest.ProtoReflect.Descriptor instead.
+func (*RequestRequest) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RequestRequest) GetMessage() *PublishRequest {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *RequestRequest) GetTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.Timeout
+	}
+	return nil
+}
+
+func (x *RequestRequest) GetMaxReplies() uint32 {
+	if x != nil {
+		return x.MaxReplies
+	}
+	return 0
+}
+
+type RequestResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Ответы в порядке получения
+	Replies       []*Event `protobuf:"bytes,1,rep,name=replies,proto3" json:"replies,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestResponse) Reset() {
+	*x = RequestResponse{}
+	mi := &file_subpub_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestResponse) ProtoMessage() {}
+
+func (x *RequestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_subpub_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestResponse.ProtoReflect.Descriptor instead.
+func (*RequestResponse) Descriptor() ([]byte, []int) {
+	return file_subpub_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *RequestResponse) GetReplies() []*Event {
+	if x != nil {
+		return x.Replies
+	}
+	return nil
+}
+
 var File_subpub_proto protoreflect.FileDescriptor
 
 const file_subpub_proto_rawDesc = "" +
 	"\n" +
-	"\fsubpub.proto\x12\x06subpub\x1a\x1bgoogle/protobuf/empty.proto\"$\n" +
+	"\fsubpub.proto\x12\x06subpub\x1a\x1egoogle/protobuf/duration.proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xfd\x01\n" +
 	"\x10SubscribeRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"6\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05group\x18\x02 \x01(\tR\x05group\x12+\n" +
+	"\x05start\x18\x03 \x01(\x0e2\x15.subpub.StartPositionR\x05start\x12%\n" +
+	"\x0estart_sequence\x18\x04 \x01(\x04R\rstartSequence\x129\n" +
+	"\n" +
+	"start_time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tstartTime\x122\n" +
+	"\boverflow\x18\x06 \x01(\x0e2\x16.subpub.OverflowPolicyR\boverflow\"\x86\x02\n" +
 	"\x0ePublishRequest\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
-	"\x04data\x18\x02 \x01(\tR\x04data\"\x1b\n" +
+	"\x04data\x18\x02 \x01(\tR\x04data\x12\x18\n" +
+	"\apayload\x18\x03 \x01(\fR\apayload\x12=\n" +
+	"\aheaders\x18\x04 \x03(\v2#.subpub.PublishRequest.HeadersEntryR\aheaders\x12!\n" +
+	"\fcontent_type\x18\x05 \x01(\tR\vcontentType\x12\x16\n" +
+	"\x06retain\x18\x06 \x01(\bR\x06retain\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"I\n" +
+	"\x13PublishBatchRequest\x122\n" +
+	"\bmessages\x18\x01 \x03(\v2\x16.subpub.PublishRequestR\bmessages\"9\n" +
+	"\rPublishResult\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"G\n" +
+	"\x14PublishBatchResponse\x12/\n" +
+	"\aresults\x18\x01 \x03(\v2\x15.subpub.PublishResultR\aresults\"\xff\x02\n" +
 	"\x05Event\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\tR\x04data2{\n" +
+	"\x04data\x18\x01 \x01(\tR\x04data\x12\x1a\n" +
+	"\bsequence\x18\x02 \x01(\x04R\bsequence\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\x12\x1a\n" +
+	"\bdelivery\x18\x04 \x01(\rR\bdelivery\x12\x18\n" +
+	"\apayload\x18\x05 \x01(\fR\apayload\x124\n" +
+	"\aheaders\x18\x06 \x03(\v2\x1a.subpub.Event.HeadersEntryR\aheaders\x12!\n" +
+	"\fcontent_type\x18\a \x01(\tR\vcontentType\x12\x0e\n" +
+	"\x02id\x18\b \x01(\tR\x02id\x12=\n" +
+	"\fpublished_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\vpublishedAt\x12\x1a\n" +
+	"\bretained\x18\n" +
+	" \x01(\bR\bretained\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"j\n" +
+	"\x0eConsumeRequest\x12,\n" +
+	"\x05start\x18\x01 \x01(\v2\x14.subpub.ConsumeStartH\x00R\x05start\x12\x1f\n" +
+	"\x03ack\x18\x02 \x01(\v2\v.subpub.AckH\x00R\x03ackB\t\n" +
+	"\arequest\"\xcb\x01\n" +
+	"\fConsumeStart\x12<\n" +
+	"\fsubscription\x18\x01 \x01(\v2\x18.subpub.SubscribeRequestR\fsubscription\x124\n" +
+	"\back_wait\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\aackWait\x12\x1f\n" +
+	"\vmax_deliver\x18\x03 \x01(\rR\n" +
+	"maxDeliver\x12&\n" +
+	"\x0fmax_ack_pending\x18\x04 \x01(\rR\rmaxAckPending\"#\n" +
+	"\x03Ack\x12\x1c\n" +
+	"\tsequences\x18\x01 \x03(\x04R\tsequences\"(\n" +
+	"\x14ClearRetainedRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"1\n" +
+	"\x15ClearRetainedResponse\x12\x18\n" +
+	"\acleared\x18\x01 \x01(\rR\acleared\"\x98\x01\n" +
+	"\x0eRequestRequest\x120\n" +
+	"\amessage\x18\x01 \x01(\v2\x16.subpub.PublishRequestR\amessage\x123\n" +
+	"\atimeout\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12\x1f\n" +
+	"\vmax_replies\x18\x03 \x01(\rR\n" +
+	"maxReplies\":\n" +
+	"\x0fRequestResponse\x12'\n" +
+	"\areplies\x18\x01 \x03(\v2\r.subpub.EventR\areplies*}\n" +
+	"\rStartPosition\x12\x19\n" +
+	"\x15START_POSITION_LATEST\x10\x00\x12\x1b\n" +
+	"\x17START_POSITION_EARLIEST\x10\x01\x12\x1b\n" +
+	"\x17START_POSITION_SEQUENCE\x10\x02\x12\x17\n" +
+	"\x13START_POSITION_TIME\x10\x03*\x8f\x01\n" +
+	"\x0eOverflowPolicy\x12\x1b\n" +
+	"\x17OVERFLOW_POLICY_DEFAULT\x10\x00\x12\x1f\n" +
+	"\x1bOVERFLOW_POLICY_DROP_OLDEST\x10\x01\x12\x1f\n" +
+	"\x1bOVERFLOW_POLICY_DROP_NEWEST\x10\x02\x12\x1e\n" +
+	"\x1aOVERFLOW_POLICY_DISCONNECT\x10\x032\xcf\x03\n" +
 	"\x06SubPub\x126\n" +
 	"\tSubscribe\x12\x18.subpub.SubscribeRequest\x1a\r.subpub.Event0\x01\x129\n" +
-	"\aPublish\x12\x16.subpub.PublishRequest\x1a\x16.google.protobuf.EmptyB+Z)github.com/imhasandl/vk-internship/protosb\x06proto3"
+	"\aPublish\x12\x16.subpub.PublishRequest\x1a\x16.google.protobuf.Empty\x12I\n" +
+	"\fPublishBatch\x12\x1b.subpub.PublishBatchRequest\x1a\x1c.subpub.PublishBatchResponse\x12G\n" +
+	"\rPublishStream\x12\x16.subpub.PublishRequest\x1a\x1c.subpub.PublishBatchResponse(\x01\x124\n" +
+	"\aConsume\x12\x16.subpub.ConsumeRequest\x1a\r.subpub.Event(\x010\x01\x12L\n" +
+	"\rClearRetained\x12\x1c.subpub.ClearRetainedRequest\x1a\x1d.subpub.ClearRetainedResponse\x12:\n" +
+	"\aRequest\x12\x16.subpub.RequestRequest\x1a\x17.subpub.RequestResponseB+Z)github.com/imhasandl/vk-internship/protosb\x06proto3"
 
 var (
 	file_subpub_proto_rawDescOnce sync.Once
@@ -190,23 +1067,65 @@ func file_subpub_proto_rawDescGZIP() []byte {
 	return file_subpub_proto_rawDescData
 }
 
-var file_subpub_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_subpub_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_subpub_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
 var file_subpub_proto_goTypes = []any{
-	(*SubscribeRequest)(nil), // 0: subpub.SubscribeRequest
-	(*PublishRequest)(nil),   // 1: subpub.PublishRequest
-	(*Event)(nil),            // 2: subpub.Event
-	(*emptypb.Empty)(nil),    // 3: google.protobuf.Empty
+	(StartPosition)(0),            // 0: subpub.StartPosition
+	(OverflowPolicy)(0),           // 1: subpub.OverflowPolicy
+	(*SubscribeRequest)(nil),      // 2: subpub.SubscribeRequest
+	(*PublishRequest)(nil),        // 3: subpub.PublishRequest
+	(*PublishBatchRequest)(nil),   // 4: subpub.PublishBatchRequest
+	(*PublishResult)(nil),         // 5: subpub.PublishResult
+	(*PublishBatchResponse)(nil),  // 6: subpub.PublishBatchResponse
+	(*Event)(nil),                 // 7: subpub.Event
+	(*ConsumeRequest)(nil),        // 8: subpub.ConsumeRequest
+	(*ConsumeStart)(nil),          // 9: subpub.ConsumeStart
+	(*Ack)(nil),                   // 10: subpub.Ack
+	(*ClearRetainedRequest)(nil),  // 11: subpub.ClearRetainedRequest
+	(*ClearRetainedResponse)(nil), // 12: subpub.ClearRetainedResponse
+	(*RequestRequest)(nil),        // 13: subpub.RequestRequest
+	(*RequestResponse)(nil),       // 14: subpub.RequestResponse
+	nil,                           // 15: subpub.PublishRequest.HeadersEntry
+	nil,                           // 16: subpub.Event.HeadersEntry
+	(*timestamppb.Timestamp)(nil), // 17: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 18: google.protobuf.Duration
+	(*emptypb.Empty)(nil),         // 19: google.protobuf.Empty
 }
 var file_subpub_proto_depIdxs = []int32{
-	0, // 0: subpub.SubPub.Subscribe:input_type -> subpub.SubscribeRequest
-	1, // 1: subpub.SubPub.Publish:input_type -> subpub.PublishRequest
-	2, // 2: subpub.SubPub.Subscribe:output_type -> subpub.Event
-	3, // 3: subpub.SubPub.Publish:output_type -> google.protobuf.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: subpub.SubscribeRequest.start:type_name -> subpub.StartPosition
+	17, // 1: subpub.SubscribeRequest.start_time:type_name -> google.protobuf.Timestamp
+	1,  // 2: subpub.SubscribeRequest.overflow:type_name -> subpub.OverflowPolicy
+	15, // 3: subpub.PublishRequest.headers:type_name -> subpub.PublishRequest.HeadersEntry
+	3,  // 4: subpub.PublishBatchRequest.messages:type_name -> subpub.PublishRequest
+	5,  // 5: subpub.PublishBatchResponse.results:type_name -> subpub.PublishResult
+	16, // 6: subpub.Event.headers:type_name -> subpub.Event.HeadersEntry
+	17, // 7: subpub.Event.published_at:type_name -> google.protobuf.Timestamp
+	9,  // 8: subpub.ConsumeRequest.start:type_name -> subpub.ConsumeStart
+	10, // 9: subpub.ConsumeRequest.ack:type_name -> subpub.Ack
+	2,  // 10: subpub.ConsumeStart.subscription:type_name -> subpub.SubscribeRequest
+	18, // 11: subpub.ConsumeStart.ack_wait:type_name -> google.protobuf.Duration
+	3,  // 12: subpub.RequestRequest.message:type_name -> subpub.PublishRequest
+	18, // 13: subpub.RequestRequest.timeout:type_name -> google.protobuf.Duration
+	7,  // 14: subpub.RequestResponse.replies:type_name -> subpub.Event
+	2,  // 15: subpub.SubPub.Subscribe:input_type -> subpub.SubscribeRequest
+	3,  // 16: subpub.SubPub.Publish:input_type -> subpub.PublishRequest
+	4,  // 17: subpub.SubPub.PublishBatch:input_type -> subpub.PublishBatchRequest
+	3,  // 18: subpub.SubPub.PublishStream:input_type -> subpub.PublishRequest
+	8,  // 19: subpub.SubPub.Consume:input_type -> subpub.ConsumeRequest
+	11, // 20: subpub.SubPub.ClearRetained:input_type -> subpub.ClearRetainedRequest
+	13, // 21: subpub.SubPub.Request:input_type -> subpub.RequestRequest
+	7,  // 22: subpub.SubPub.Subscribe:output_type -> subpub.Event
+	19, // 23: subpub.SubPub.Publish:output_type -> google.protobuf.Empty
+	6,  // 24: subpub.SubPub.PublishBatch:output_type -> subpub.PublishBatchResponse
+	6,  // 25: subpub.SubPub.PublishStream:output_type -> subpub.PublishBatchResponse
+	7,  // 26: subpub.SubPub.Consume:output_type -> subpub.Event
+	12, // 27: subpub.SubPub.ClearRetained:output_type -> subpub.ClearRetainedResponse
+	14, // 28: subpub.SubPub.Request:output_type -> subpub.RequestResponse
+	22, // [22:29] is the sub-list for method output_type
+	15, // [15:22] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
 }
 
 func init() { file_subpub_proto_init() }
@@ -214,18 +1133,23 @@ func file_subpub_proto_init() {
 	if File_subpub_proto != nil {
 		return
 	}
+	file_subpub_proto_msgTypes[6].OneofWrappers = []any{
+		(*ConsumeRequest_Start)(nil),
+		(*ConsumeRequest_Ack)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_subpub_proto_rawDesc), len(file_subpub_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      2,
+			NumMessages:   15,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_subpub_proto_goTypes,
 		DependencyIndexes: file_subpub_proto_depIdxs,
+		EnumInfos:         file_subpub_proto_enumTypes,
 		MessageInfos:      file_subpub_proto_msgTypes,
 	}.Build()
 	File_subpub_proto = out.File
@@ -20,8 +20,13 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	SubPub_Subscribe_FullMethodName = "/subpub.SubPub/Subscribe"
-	SubPub_Publish_FullMethodName   = "/subpub.SubPub/Publish"
+	SubPub_Subscribe_FullMethodName     = "/subpub.SubPub/Subscribe"
+	SubPub_Publish_FullMethodName       = "/subpub.SubPub/Publish"
+	SubPub_PublishBatch_FullMethodName  = "/subpub.SubPub/PublishBatch"
+	SubPub_PublishStream_FullMethodName = "/subpub.SubPub/PublishStream"
+	SubPub_Consume_FullMethodName       = "/subpub.SubPub/Consume"
+	SubPub_ClearRetained_FullMethodName = "/subpub.SubPub/ClearRetained"
+	SubPub_Request_FullMethodName       = "/subpub.SubPub/Request"
 )
 
 // SubPubClient is the client API for SubPub service.
@@ -30,6 +35,18 @@ const (
 type SubPubClient interface {
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
 	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Публикует несколько сообщений за один запрос
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error)
+	// Поток публикаций: сервер публикует сообщения по мере получения и
+	// возвращает результаты, когда клиент закрывает поток
+	PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse], error)
+	// Подписка с подтверждением: первое сообщение клиента - ConsumeStart,
+	// дальше клиент подтверждает обработанные события по их номерам
+	Consume(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConsumeRequest, Event], error)
+	// Удаляет сохраненные значения тем, совпадающих с ключом
+	ClearRetained(ctx context.Context, in *ClearRetainedRequest, opts ...grpc.CallOption) (*ClearRetainedResponse, error)
+	// Публикует запрос с заголовком reply-to и ждет ответов подписчиков
+	Request(ctx context.Context, in *RequestRequest, opts ...grpc.CallOption) (*RequestResponse, error)
 }
 
 type subPubClient struct {
@@ -69,12 +86,80 @@ func (c *subPubClient) Publish(ctx context.Context, in *PublishRequest, opts ...
 	return out, nil
 }
 
+func (c *subPubClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishBatchResponse)
+	err := c.cc.Invoke(ctx, SubPub_PublishBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subPubClient) PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SubPub_ServiceDesc.Streams[1], SubPub_PublishStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PublishRequest, PublishBatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SubPub_PublishStreamClient = grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse]
+
+func (c *subPubClient) Consume(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConsumeRequest, Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SubPub_ServiceDesc.Streams[2], SubPub_Consume_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConsumeRequest, Event]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SubPub_ConsumeClient = grpc.BidiStreamingClient[ConsumeRequest, Event]
+
+func (c *subPubClient) ClearRetained(ctx context.Context, in *ClearRetainedRequest, opts ...grpc.CallOption) (*ClearRetainedResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearRetainedResponse)
+	err := c.cc.Invoke(ctx, SubPub_ClearRetained_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subPubClient) Request(ctx context.Context, in *RequestRequest, opts ...grpc.CallOption) (*RequestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RequestResponse)
+	err := c.cc.Invoke(ctx, SubPub_Request_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SubPubServer is the server API for SubPub service.
 // All implementations must embed UnimplementedSubPubServer
 // for forward compatibility.
 type SubPubServer interface {
 	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error
 	Publish(context.Context, *PublishRequest) (*emptypb.Empty, error)
+	// Публикует несколько сообщений за один запрос
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error)
+	// Поток публикаций: сервер публикует сообщения по мере получения и
+	// возвращает результаты, когда клиент закрывает поток
+	PublishStream(grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]) error
+	// Подписка с подтверждением: первое сообщение клиента - ConsumeStart,
+	// дальше клиент подтверждает обработанные события по их номерам
+	Consume(grpc.BidiStreamingServer[ConsumeRequest, Event]) error
+	// Удаляет сохраненные значения тем, совпадающих с ключом
+	ClearRetained(context.Context, *ClearRetainedRequest) (*ClearRetainedResponse, error)
+	// Публикует запрос с заголовком reply-to и ждет ответов подписчиков
+	Request(context.Context, *RequestRequest) (*RequestResponse, error)
 	mustEmbedUnimplementedSubPubServer()
 }
 
@@ -91,6 +176,21 @@ func (UnimplementedSubPubServer) Subscribe(*SubscribeRequest, grpc.ServerStreami
 func (UnimplementedSubPubServer) Publish(context.Context, *PublishRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedSubPubServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
+func (UnimplementedSubPubServer) PublishStream(grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PublishStream not implemented")
+}
+func (UnimplementedSubPubServer) Consume(grpc.BidiStreamingServer[ConsumeRequest, Event]) error {
+	return status.Errorf(codes.Unimplemented, "method Consume not implemented")
+}
+func (UnimplementedSubPubServer) ClearRetained(context.Context, *ClearRetainedRequest) (*ClearRetainedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearRetained not implemented")
+}
+func (UnimplementedSubPubServer) Request(context.Context, *RequestRequest) (*RequestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Request not implemented")
+}
 func (UnimplementedSubPubServer) mustEmbedUnimplementedSubPubServer() {}
 func (UnimplementedSubPubServer) testEmbeddedByValue()                {}
 
@@ -141,6 +241,74 @@ func _SubPub_Publish_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SubPub_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubPubServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SubPub_PublishBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubPubServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SubPub_PublishStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SubPubServer).PublishStream(&grpc.GenericServerStream[PublishRequest, PublishBatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SubPub_PublishStreamServer = grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]
+
+func _SubPub_Consume_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SubPubServer).Consume(&grpc.GenericServerStream[ConsumeRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SubPub_ConsumeServer = grpc.BidiStreamingServer[ConsumeRequest, Event]
+
+func _SubPub_ClearRetained_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearRetainedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubPubServer).ClearRetained(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SubPub_ClearRetained_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubPubServer).ClearRetained(ctx, req.(*ClearRetainedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SubPub_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubPubServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SubPub_Request_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubPubServer).Request(ctx, req.(*RequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SubPub_ServiceDesc is the grpc.ServiceDesc for SubPub service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -152,6 +320,18 @@ var SubPub_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _SubPub_Publish_Handler,
 		},
+		{
+			MethodName: "PublishBatch",
+			Handler:    _SubPub_PublishBatch_Handler,
+		},
+		{
+			MethodName: "ClearRetained",
+			Handler:    _SubPub_ClearRetained_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _SubPub_Request_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -159,6 +339,17 @@ var SubPub_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _SubPub_Subscribe_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "PublishStream",
+			Handler:       _SubPub_PublishStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Consume",
+			Handler:       _SubPub_Consume_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "subpub.proto",
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+)
+
+const (
+	// defaultAckWait - время ожидания подтверждения по умолчанию
+	defaultAckWait = 30 * time.Second
+	// defaultMaxDeliver - число попыток доставки по умолчанию
+	defaultMaxDeliver = 5
+	// defaultMaxAckPending - число неподтвержденных событий по умолчанию
+	defaultMaxAckPending = 1000
+	// dlqPrefix - префикс тем, куда попадают недоставленные события
+	dlqPrefix = "$DLQ."
+	// dlqSubject принимает недоставленные события, для ключа которых тема
+	// $DLQ.<key> превысила бы ограничения на длину или число токенов
+	dlqSubject = "$DLQ"
+	// dlqKeyHeader хранит исходный ключ события, попавшего в dlqSubject
+	dlqKeyHeader = "dlq-key"
+)
+
+// pendingEvent - отправленное, но еще не подтвержденное событие
+type pendingEvent struct {
+	event    *pb.Event
+	deadline time.Time
+}
+
+// ackTracker отслеживает неподтвержденные события одного потока Consume
+type ackTracker struct {
+	wait       time.Duration
+	maxDeliver uint32
+	maxPending uint32
+	pending    map[uint64]*pendingEvent
+}
+
+func newAckTracker(wait time.Duration, maxDeliver, maxPending uint32) *ackTracker {
+	return &ackTracker{
+		wait:       wait,
+		maxDeliver: maxDeliver,
+		maxPending: maxPending,
+		pending:    make(map[uint64]*pendingEvent),
+	}
+}
+
+// full сообщает, что новых событий нельзя отправлять до подтверждения старых
+func (t *ackTracker) full() bool {
+	return t.maxPending > 0 && len(t.pending) >= int(t.maxPending)
+}
+
+// sent запоминает отправленное событие до подтверждения
+func (t *ackTracker) sent(event *pb.Event, now time.Time) {
+	t.pending[event.Sequence] = &pendingEvent{
+		event:    event,
+		deadline: now.Add(t.wait),
+	}
+}
+
+// ack отмечает события подтвержденными
+func (t *ackTracker) ack(sequences []uint64) {
+	for _, seq := range sequences {
+		delete(t.pending, seq)
+	}
+}
+
+// expired забирает события, время подтверждения которых истекло, и делит их
+// на те, что нужно доставить повторно, и те, что исчерпали попытки
+func (t *ackTracker) expired(now time.Time) (redeliver, dead []*pb.Event) {
+	for seq, p := range t.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		delete(t.pending, seq)
+		if t.maxDeliver > 0 && p.event.Delivery >= t.maxDeliver {
+			dead = append(dead, p.event)
+		} else {
+			redeliver = append(redeliver, p.event)
+		}
+	}
+
+	// Повторно доставляем в порядке публикации
+	sort.Slice(redeliver, func(i, j int) bool {
+		return redeliver[i].Sequence < redeliver[j].Sequence
+	})
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].Sequence < dead[j].Sequence
+	})
+	return redeliver, dead
+}
+
+// tick - период проверки истекших подтверждений
+func (t *ackTracker) tick() time.Duration {
+	tick := t.wait / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	return tick
+}
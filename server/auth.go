@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/imhasandl/vk-internship/auth"
+	"github.com/imhasandl/vk-internship/helper"
+	"google.golang.org/grpc/codes"
+)
+
+// WithAuthorizer включает проверку прав клиентов на публикацию и подписку.
+// Идентичность клиента задают перехватчики auth или сертификат при mTLS.
+func WithAuthorizer(a auth.Authorizer) Option {
+	return func(s *apiConfig) {
+		s.authorizer = a
+	}
+}
+
+// authorize проверяет, может ли клиент выполнить действие над темой или
+// шаблоном тем. Без WithAuthorizer разрешено все.
+func (s *apiConfig) authorize(ctx context.Context, action auth.Action, subject string) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "authentication required", nil)
+	}
+	if !s.authorizer.Allowed(id, action, subject) {
+		return helper.RespondWithErrorGRPC(ctx, codes.PermissionDenied, "permission denied", nil,
+			helper.ResourceInfo("subject", subject, fmt.Sprintf("%s is not allowed to %s", id.Name, action)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/vk-internship/auth"
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// namedTokens - токен совпадает с именем клиента
+type namedTokens struct{}
+
+func (namedTokens) Verify(_ context.Context, token string) (auth.Identity, error) {
+	return auth.Identity{Name: token, Method: auth.MethodToken}, nil
+}
+
+func TestAuthorization(t *testing.T) {
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Identities: []string{"billing"}, Actions: []string{"publish"}, Subjects: []string{"orders.>"}, Effect: "allow"},
+		{Identities: []string{"*"}, Actions: []string{"subscribe"}, Subjects: []string{"orders.>"}, Effect: "allow"},
+		{Identities: []string{"*"}, Actions: []string{"*"}, Subjects: []string{"orders.internal"}, Effect: "deny"},
+	})
+	require.NoError(t, err)
+
+	authenticator := auth.NewAuthenticator(namedTokens{})
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub(), WithAuthorizer(policy)),
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()))
+
+	as := func(name string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+name)
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{"Публикация разрешена", func() error {
+			_, err := client.Publish(as("billing"), &pb.PublishRequest{Key: "orders.eu", Data: "a"})
+			return err
+		}, codes.OK},
+		{"Публикация без права", func() error {
+			_, err := client.Publish(as("audit"), &pb.PublishRequest{Key: "orders.eu", Data: "a"})
+			return err
+		}, codes.PermissionDenied},
+		{"Публикация в запрещенную тему", func() error {
+			_, err := client.Publish(as("billing"), &pb.PublishRequest{Key: "orders.internal", Data: "a"})
+			return err
+		}, codes.PermissionDenied},
+		{"Публикация без токена", func() error {
+			_, err := client.Publish(context.Background(), &pb.PublishRequest{Key: "orders.eu", Data: "a"})
+			return err
+		}, codes.Unauthenticated},
+		{"Очистка без права", func() error {
+			_, err := client.ClearRetained(as("audit"), &pb.ClearRetainedRequest{Key: "orders.>"})
+			return err
+		}, codes.PermissionDenied},
+		{"Подписка на шаблон, пересекающий запрет", func() error {
+			return recvErr(client.Subscribe(as("audit"), &pb.SubscribeRequest{Key: "orders.*"}))
+		}, codes.PermissionDenied},
+		{"Подписка вне разрешения", func() error {
+			return recvErr(client.Subscribe(as("audit"), &pb.SubscribeRequest{Key: "payments"}))
+		}, codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantCode == codes.PermissionDenied {
+				details := status.Convert(err).Details()
+				require.Len(t, details, 1)
+				assert.IsType(t, &errdetails.ResourceInfo{}, details[0])
+			}
+		})
+	}
+
+	// Разрешенная подписка получает сообщения
+	ctx, cancel := context.WithCancel(as("audit"))
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders.eu"})
+	require.NoError(t, err)
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+	_, err = client.Publish(as("billing"), &pb.PublishRequest{Key: "orders.eu", Data: "a"})
+	require.NoError(t, err)
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "a", event.Data)
+}
+
+// recvErr возвращает ошибку, с которой сервер завершил поток
+func recvErr(stream pb.SubPub_SubscribeClient, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
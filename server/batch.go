@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"google.golang.org/grpc/status"
+)
+
+// publishStreamBatch - сколько сообщений PublishStream публикует одной пачкой
+const publishStreamBatch = 256
+
+// PublishBatch публикует сообщения запроса одной пачкой. Ошибки отдельных
+// сообщений возвращаются в результатах, а не статусом всего запроса.
+func (s *apiConfig) PublishBatch(ctx context.Context, req *pb.PublishBatchRequest) (*pb.PublishBatchResponse, error) {
+	ctx = s.requestContext(ctx, "PublishBatch", "")
+
+	if err := s.unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	return &pb.PublishBatchResponse{Results: s.publishBatch(ctx, req.Messages)}, nil
+}
+
+// PublishStream публикует сообщения потока. Все, что клиент успел прислать,
+// пока публиковалась предыдущая пачка, публикуется следующей пачкой.
+func (s *apiConfig) PublishStream(stream pb.SubPub_PublishStreamServer) error {
+	ctx := s.requestContext(stream.Context(), "PublishStream", "")
+
+	if err := s.unavailable(ctx); err != nil {
+		return err
+	}
+
+	reqs := make(chan *pb.PublishRequest, publishStreamBatch)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr <- err
+				}
+				return
+			}
+			reqs <- req
+		}
+	}()
+
+	var results []*pb.PublishResult
+	batch := make([]*pb.PublishRequest, 0, publishStreamBatch)
+	for req := range reqs {
+		batch = append(batch[:0], req)
+	fill:
+		for len(batch) < publishStreamBatch {
+			select {
+			case req, ok := <-reqs:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, req)
+			default:
+				break fill
+			}
+		}
+		results = append(results, s.publishBatch(ctx, batch)...)
+	}
+
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+	}
+	return stream.SendAndClose(&pb.PublishBatchResponse{Results: results})
+}
+
+// publishBatch проверяет сообщения и публикует допустимые одним вызовом брокера
+func (s *apiConfig) publishBatch(ctx context.Context, reqs []*pb.PublishRequest) []*pb.PublishResult {
+	results := make([]*pb.PublishResult, len(reqs))
+	msgs := make([]*subpub.Message, 0, len(reqs))
+	indexes := make([]int, 0, len(reqs))
+
+	traceCtx := incomingTrace(ctx)
+	for i, req := range reqs {
+		if err := s.checkPublish(ctx, req); err != nil {
+			results[i] = publishResult(err)
+			continue
+		}
+		msg := publishMessage(req)
+		msg.Headers = subpub.InjectTrace(traceCtx, msg.Headers)
+		msgs = append(msgs, msg)
+		indexes = append(indexes, i)
+	}
+
+	for j, err := range s.PubSub.PublishBatch(msgs) {
+		i := indexes[j]
+		if err != nil {
+			err = brokerError(ctx, "key", reqs[i].Key, err)
+		}
+		results[i] = publishResult(err)
+	}
+	return results
+}
+
+// publishResult переводит ошибку публикации сообщения в его результат
+func publishResult(err error) *pb.PublishResult {
+	if err == nil {
+		return &pb.PublishResult{}
+	}
+	st := status.Convert(err)
+	return &pb.PublishResult{Code: int32(st.Code()), Error: st.Message()}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPublishBatch(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	received := make(chan string, 10)
+	_, err := pubSub.Subscribe("orders.>", func(msg interface{}) {
+		received <- msg.(string)
+	})
+	require.NoError(t, err)
+
+	resp, err := client.PublishBatch(context.Background(), &pb.PublishBatchRequest{
+		Messages: []*pb.PublishRequest{
+			{Key: "orders.eu", Data: "a"},
+			{Key: "orders..eu", Data: "bad"},
+			{Key: "$DLQ.orders", Data: "reserved"},
+			{Key: "orders.us", Data: "b"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []codes.Code{codes.OK, codes.InvalidArgument, codes.InvalidArgument, codes.OK},
+		resultCodes(resp.Results))
+	assert.Equal(t, "a", <-received)
+	assert.Equal(t, "b", <-received)
+}
+
+func TestPublishStream(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	const n = 1000
+	received := make(chan string, n)
+	_, err := pubSub.Subscribe("events", func(msg interface{}) {
+		received <- msg.(string)
+	})
+	require.NoError(t, err)
+
+	stream, err := client.PublishStream(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		key := "events"
+		if i == 10 {
+			key = "events.>"
+		}
+		require.NoError(t, stream.Send(&pb.PublishRequest{Key: key, Data: fmt.Sprint(i)}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	require.Len(t, resp.Results, n)
+	for i, code := range resultCodes(resp.Results) {
+		if i == 10 {
+			assert.Equal(t, codes.InvalidArgument, code)
+			continue
+		}
+		require.Equal(t, codes.OK, code, "Сообщение %d", i)
+	}
+
+	// Сообщения приходят в порядке отправки
+	for i := 0; i < n; i++ {
+		if i == 10 {
+			continue
+		}
+		require.Equal(t, fmt.Sprint(i), <-received)
+	}
+}
+
+func resultCodes(results []*pb.PublishResult) []codes.Code {
+	got := make([]codes.Code, len(results))
+	for i, r := range results {
+		got[i] = codes.Code(r.Code)
+	}
+	return got
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/imhasandl/vk-internship/helper"
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// Consume доставляет события с подтверждением (at-least-once). Событие,
+// которое клиент не подтвердил за ack_wait, отправляется повторно, а после
+// max_deliver попыток публикуется в тему $DLQ.<key>. Неподтвержденные события
+// не переживают разрыв потока: клиент продолжает чтение журнала с номера
+// первого неподтвержденного события. Поэтому клиент, не успевающий читать
+// поток, отключается, а не теряет события.
+func (s *apiConfig) Consume(stream pb.SubPub_ConsumeServer) error {
+	ctx, cancel := context.WithCancel(s.requestContext(stream.Context(), "Consume", ""))
+	defer cancel()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStart()
+	if start == nil || start.Subscription == nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "first message must be ConsumeStart", nil,
+			helper.BadRequest("start", "first message must be ConsumeStart with subscription"))
+	}
+	ctx = helper.WithLogger(ctx, helper.Logger(ctx).With(slog.String("subject", start.Subscription.Key)))
+
+	tracker := newAckTracker(s.ackWait, s.maxDeliver, s.maxPending)
+	if start.AckWait != nil {
+		if err := start.AckWait.CheckValid(); err != nil || start.AckWait.AsDuration() <= 0 {
+			return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid ack_wait", err,
+				helper.BadRequest("start.ack_wait", "must be a positive duration"))
+		}
+		tracker.wait = start.AckWait.AsDuration()
+	}
+	if start.MaxDeliver > 0 {
+		tracker.maxDeliver = start.MaxDeliver
+	}
+	if start.MaxAckPending > 0 {
+		tracker.maxPending = start.MaxAckPending
+	}
+
+	msgChan := make(chan *pb.Event)
+	handler := func(msg *subpub.Message) {
+		event, ok := s.newEvent(ctx, msg)
+		if !ok {
+			return
+		}
+		select {
+		case msgChan <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	subscription, err := s.subscribe(ctx, start.Subscription, handler, consumeOverflowPolicy)
+	if err != nil {
+		return err
+	}
+	defer subscription.Unsubscribe()
+
+	// Подтверждения читаются в отдельной горутине, чтобы не блокировать отправку
+	acks := make(chan []uint64)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			ack := req.GetAck()
+			if ack == nil {
+				recvErr <- errors.New("unexpected ConsumeStart")
+				return
+			}
+			select {
+			case acks <- ack.Sequences:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(tracker.tick())
+	defer ticker.Stop()
+
+	send := func(event *pb.Event) error {
+		event.Delivery++
+		tracker.sent(event, time.Now())
+		// Отправляем копию, чтобы номер попытки не менялся у уже отправленного события
+		if err := stream.Send(proto.Clone(event).(*pb.Event)); err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "Failed to send message", err)
+		}
+		return nil
+	}
+
+	for {
+		// Пока достигнут лимит неподтвержденных, новые события ждут в очереди
+		// подписчика; переполнение очереди отключает клиента
+		events := msgChan
+		if tracker.full() {
+			events = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-subscription.Done():
+			return s.subscriptionEnded(ctx, start.Subscription.Key, subscription)
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				// Клиент закрыл свою сторону потока и больше не подтверждает события
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid consume request", err,
+				helper.BadRequest("ack", err.Error()))
+		case sequences := <-acks:
+			tracker.ack(sequences)
+		case event := <-events:
+			if err := send(event); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			redeliver, dead := tracker.expired(now)
+			for _, event := range dead {
+				s.deadLetter(ctx, event)
+			}
+			for _, event := range redeliver {
+				if err := send(event); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// consumeOverflowPolicy - политика переполнения для Consume. Вытесненное из
+// очереди событие не попадает в ackTracker и не доставляется повторно,
+// поэтому медленного клиента отключаем: он продолжит чтение журнала
+// с первого неподтвержденного события.
+func consumeOverflowPolicy(p pb.OverflowPolicy) (subpub.OverflowPolicy, error) {
+	switch p {
+	case pb.OverflowPolicy_OVERFLOW_POLICY_DEFAULT, pb.OverflowPolicy_OVERFLOW_POLICY_DISCONNECT:
+		return subpub.Disconnect, nil
+	default:
+		return 0, errors.New("consume supports only the disconnect overflow policy")
+	}
+}
+
+// deadLetter публикует событие, исчерпавшее попытки доставки, в $DLQ.<key>,
+// а если такая тема нарушила бы ограничения - в $DLQ с ключом в заголовке.
+// Публикуются исходные байты: строковое поле события пусто для данных не в UTF-8.
+func (s *apiConfig) deadLetter(ctx context.Context, event *pb.Event) {
+	msg := &subpub.Message{
+		Subject:     dlqPrefix + event.Key,
+		Headers:     event.Headers,
+		ContentType: event.ContentType,
+		Data:        event.Payload,
+	}
+	if subpub.ValidateSubject(msg.Subject) != nil {
+		// Ключ у границы лимитов: событие не должно потеряться из-за префикса
+		msg.Subject = dlqSubject
+		msg.Headers = maps.Clone(event.Headers)
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string, 1)
+		}
+		msg.Headers[dlqKeyHeader] = event.Key
+	}
+	err := s.PubSub.PublishMsg(msg)
+	if err != nil {
+		helper.Logger(ctx).Error("failed to publish event to dead letter queue",
+			slog.Uint64("seq", event.Sequence),
+			slog.Any("error", err))
+	}
+}
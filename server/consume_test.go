@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// startTestServer поднимает gRPC сервер в памяти и возвращает клиента к нему
+func startTestServer(t *testing.T, server pb.SubPubServer, opts ...grpc.ServerOption) pb.SubPubClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(opts...)
+	pb.RegisterSubPubServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewSubPubClient(conn)
+}
+
+// startConsume открывает поток Consume и ждет, пока подписка будет установлена
+func startConsume(t *testing.T, client pb.SubPubClient, start *pb.ConsumeStart) pb.SubPub_ConsumeClient {
+	t.Helper()
+
+	stream, err := client.Consume(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.ConsumeRequest{
+		Request: &pb.ConsumeRequest_Start{Start: start},
+	}))
+	t.Cleanup(func() { stream.CloseSend() })
+
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+	return stream
+}
+
+// Бинарные данные и метаданные проходят через сервер без изменений,
+// а клиенты, знающие только строковое поле, продолжают работать
+func TestPublishPayload(t *testing.T) {
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "files"})
+	require.NoError(t, err)
+
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+
+	binary := []byte{0x1f, 0x8b, 0x08, 0x00, 0xff}
+	_, err = client.Publish(ctx, &pb.PublishRequest{
+		Key:         "files",
+		Payload:     binary,
+		Headers:     map[string]string{"name": "archive.gz"},
+		ContentType: "application/gzip",
+	})
+	require.NoError(t, err)
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "files", Data: "text"})
+	require.NoError(t, err)
+
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, binary, first.Payload)
+	assert.Empty(t, first.Data, "Данные не являются строкой UTF-8")
+	assert.Equal(t, map[string]string{"name": "archive.gz"}, first.Headers)
+	assert.Equal(t, "application/gzip", first.ContentType)
+	assert.NotEmpty(t, first.Id)
+	assert.WithinDuration(t, time.Now(), first.PublishedAt.AsTime(), time.Second)
+
+	second, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "text", second.Data)
+	assert.Equal(t, []byte("text"), second.Payload)
+	assert.NotEqual(t, first.Id, second.Id)
+}
+
+func TestConsumeRedelivery(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	stream := startConsume(t, client, &pb.ConsumeStart{
+		Subscription: &pb.SubscribeRequest{Key: "jobs"},
+		AckWait:      durationpb.New(100 * time.Millisecond),
+	})
+
+	require.NoError(t, pubSub.Publish("jobs", "first"))
+	require.NoError(t, pubSub.Publish("jobs", "second"))
+
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "first", first.Data)
+	assert.Equal(t, uint32(1), first.Delivery)
+
+	second, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "second", second.Data)
+
+	// Подтверждаем только первое событие
+	require.NoError(t, stream.Send(&pb.ConsumeRequest{
+		Request: &pb.ConsumeRequest_Ack{Ack: &pb.Ack{Sequences: []uint64{first.Sequence}}},
+	}))
+
+	redelivered, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, second.Sequence, redelivered.Sequence)
+	assert.Equal(t, "second", redelivered.Data)
+	assert.Equal(t, uint32(2), redelivered.Delivery)
+
+	require.NoError(t, stream.Send(&pb.ConsumeRequest{
+		Request: &pb.ConsumeRequest_Ack{Ack: &pb.Ack{Sequences: []uint64{redelivered.Sequence}}},
+	}))
+
+	// После подтверждения событие больше не приходит
+	require.NoError(t, pubSub.Publish("jobs", "third"))
+	third, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "third", third.Data)
+	assert.Equal(t, uint32(1), third.Delivery)
+}
+
+func TestConsumeDeadLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *subpub.Message
+		want *subpub.Message
+	}{
+		{
+			name: "Строка",
+			msg:  &subpub.Message{Subject: "jobs.eu", Data: "poison"},
+			want: &subpub.Message{Subject: "$DLQ.jobs.eu", Data: []byte("poison")},
+		},
+		{
+			name: "Данные не в UTF-8 с метаданными",
+			msg: &subpub.Message{
+				Subject:     "jobs.eu",
+				Headers:     map[string]string{"name": "archive.gz"},
+				ContentType: "application/gzip",
+				Data:        []byte{0x1f, 0x8b, 0xff},
+			},
+			want: &subpub.Message{
+				Subject:     "$DLQ.jobs.eu",
+				Headers:     map[string]string{"name": "archive.gz"},
+				ContentType: "application/gzip",
+				Data:        []byte{0x1f, 0x8b, 0xff},
+			},
+		},
+		{
+			name: "Ключ с наибольшим числом токенов",
+			msg:  &subpub.Message{Subject: "a.b.c.d.e.f.g.h.i.j.k.l.m.n.o.p", Data: "poison"},
+			want: &subpub.Message{
+				Subject: "$DLQ",
+				Headers: map[string]string{"dlq-key": "a.b.c.d.e.f.g.h.i.j.k.l.m.n.o.p"},
+				Data:    []byte("poison"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubSub := subpub.NewSubPub()
+			client := startTestServer(t, NewServer("test-port", pubSub, WithMaxDeliver(2)))
+
+			dead := make(chan *subpub.Message, 1)
+			for _, pattern := range []string{"$DLQ.>", "$DLQ"} {
+				_, err := pubSub.SubscribeMsg(pattern, "", func(msg *subpub.Message) {
+					dead <- msg
+				})
+				require.NoError(t, err)
+			}
+
+			stream := startConsume(t, client, &pb.ConsumeStart{
+				Subscription: &pb.SubscribeRequest{Key: tt.msg.Subject},
+				AckWait:      durationpb.New(50 * time.Millisecond),
+			})
+
+			require.NoError(t, pubSub.PublishMsg(tt.msg))
+
+			// Событие не подтверждается ни разу
+			for delivery := uint32(1); delivery <= 2; delivery++ {
+				event, err := stream.Recv()
+				require.NoError(t, err)
+				assert.Equal(t, delivery, event.Delivery)
+			}
+
+			select {
+			case msg := <-dead:
+				assert.Equal(t, tt.want.Subject, msg.Subject)
+				assert.Equal(t, tt.want.Data, msg.Data)
+				assert.Equal(t, tt.want.Headers, msg.Headers)
+				assert.Equal(t, tt.want.ContentType, msg.ContentType)
+			case <-time.After(time.Second):
+				t.Fatal("Таймаут: событие не попало в очередь недоставленных")
+			}
+		})
+	}
+}
+
+func TestConsumeInvalidStart(t *testing.T) {
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub()))
+
+	tests := []struct {
+		name string
+		req  *pb.ConsumeRequest
+	}{
+		{
+			name: "Подтверждение до подписки",
+			req: &pb.ConsumeRequest{
+				Request: &pb.ConsumeRequest_Ack{Ack: &pb.Ack{Sequences: []uint64{1}}},
+			},
+		},
+		{
+			name: "Отрицательное время ожидания",
+			req: &pb.ConsumeRequest{
+				Request: &pb.ConsumeRequest_Start{Start: &pb.ConsumeStart{
+					Subscription: &pb.SubscribeRequest{Key: "jobs"},
+					AckWait:      durationpb.New(-time.Second),
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := client.Consume(context.Background())
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(tt.req))
+
+			_, err = stream.Recv()
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+// Медленный клиент Consume отключается, а не теряет события: вытесненное из
+// очереди событие не было бы доставлено повторно
+func TestConsumeOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow pb.OverflowPolicy
+		wantCode codes.Code
+	}{
+		{"По умолчанию отключение", pb.OverflowPolicy_OVERFLOW_POLICY_DEFAULT, codes.ResourceExhausted},
+		{"Отключение", pb.OverflowPolicy_OVERFLOW_POLICY_DISCONNECT, codes.ResourceExhausted},
+		{"Вытеснение старых", pb.OverflowPolicy_OVERFLOW_POLICY_DROP_OLDEST, codes.InvalidArgument},
+		{"Отбрасывание новых", pb.OverflowPolicy_OVERFLOW_POLICY_DROP_NEWEST, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubSub := subpub.NewSubPub(subpub.WithQueueSize(4))
+			client := startTestServer(t, NewServer("test-port", pubSub))
+
+			stream := startConsume(t, client, &pb.ConsumeStart{
+				Subscription: &pb.SubscribeRequest{Key: "jobs", Overflow: tt.overflow},
+			})
+			if tt.wantCode == codes.InvalidArgument {
+				_, err := stream.Recv()
+				assert.Equal(t, tt.wantCode, status.Code(err))
+				return
+			}
+
+			// Клиент не читает поток: после окна управления потоком gRPC
+			// заполняется очередь подписчика
+			payload := make([]byte, 1024)
+			published := make(chan error, 1)
+			go func() {
+				for i := 0; i < 2000; i++ {
+					if err := pubSub.PublishMsg(&subpub.Message{Subject: "jobs", Data: payload}); err != nil {
+						published <- err
+						return
+					}
+				}
+				published <- nil
+			}()
+			select {
+			case err := <-published:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Publish ждет клиента, который не читает поток")
+			}
+
+			ended := make(chan error, 1)
+			go func() {
+				for {
+					if _, err := stream.Recv(); err != nil {
+						ended <- err
+						return
+					}
+				}
+			}()
+			select {
+			case err := <-ended:
+				assert.Equal(t, tt.wantCode, status.Code(err))
+			case <-time.After(5 * time.Second):
+				t.Fatal("Consume не отключил медленного клиента")
+			}
+		})
+	}
+}
+
+func TestConsumeMaxAckPending(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	stream := startConsume(t, client, &pb.ConsumeStart{
+		Subscription:  &pb.SubscribeRequest{Key: "jobs"},
+		AckWait:       durationpb.New(time.Minute),
+		MaxAckPending: 2,
+	})
+
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, pubSub.Publish("jobs", data))
+	}
+
+	events := make(chan *pb.Event, 3)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+
+	first := <-events
+	second := <-events
+	assert.Equal(t, []string{"a", "b"}, []string{first.Data, second.Data})
+
+	// Третье событие ждет, пока клиент не подтвердит одно из отправленных
+	select {
+	case event := <-events:
+		t.Fatalf("Событие %q отправлено сверх max_ack_pending", event.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, stream.Send(&pb.ConsumeRequest{
+		Request: &pb.ConsumeRequest_Ack{Ack: &pb.Ack{Sequences: []uint64{first.Sequence}}},
+	}))
+	select {
+	case event := <-events:
+		assert.Equal(t, "c", event.Data)
+	case <-time.After(time.Second):
+		t.Fatal("Таймаут: событие не отправлено после подтверждения")
+	}
+}
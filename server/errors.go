@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/imhasandl/vk-internship/helper"
+	"github.com/imhasandl/vk-internship/limits"
+	"github.com/imhasandl/vk-internship/store"
+	"github.com/imhasandl/vk-internship/subpub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// brokerError переводит ошибку брокера в статус gRPC. field - поле запроса с
+// темой, subject - тема или шаблон подписки, к которым относится ошибка.
+func brokerError(ctx context.Context, field, subject string, err error) error {
+	var subjectErr *subpub.SubjectError
+	var limitErr *limits.Error
+	switch {
+	case errors.As(err, &subjectErr):
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid subject: "+subjectErr.Reason, err,
+			helper.BadRequest(field, subjectErr.Reason))
+	case errors.Is(err, subpub.ErrClosed):
+		return helper.RespondWithErrorGRPC(ctx, codes.Unavailable, errShuttingDown.Error(), err)
+	case errors.Is(err, subpub.ErrInvalidSubject):
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid subject", err,
+			helper.BadRequest(field, err.Error()))
+	case errors.Is(err, subpub.ErrNotPersistable):
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "message cannot be persisted", err,
+			helper.BadRequest("payload", err.Error()))
+	case errors.Is(err, store.ErrRecordTooLarge):
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "message is too large to persist", err,
+			helper.BadRequest("payload", err.Error()))
+	case errors.Is(err, subpub.ErrSlowConsumer):
+		return helper.RespondWithErrorGRPC(ctx, codes.ResourceExhausted, "subscriber is too slow", err,
+			helper.ResourceInfo("subscription", subject, "subscriber queue overflowed"))
+	case errors.Is(err, subpub.ErrTooManyPanics):
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "subscription failed", err,
+			helper.ResourceInfo("subscription", subject, err.Error()))
+	case errors.As(err, &limitErr):
+		return limitExceeded(ctx, limitErr)
+	case errors.Is(err, subpub.ErrQuotaExceeded):
+		return helper.RespondWithErrorGRPC(ctx, codes.ResourceExhausted, "quota exceeded", err,
+			helper.ResourceInfo("subject", subject, err.Error()))
+	case errors.Is(err, subpub.ErrNoStore):
+		return helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "message log is disabled", err,
+			helper.ResourceInfo("message log", "", "start positions other than latest require DATA_DIR"))
+	case errors.Is(err, subpub.ErrNoResponders):
+		return helper.RespondWithErrorGRPC(ctx, codes.NotFound, "no responders", err,
+			helper.ResourceInfo("subject", subject, "nobody is subscribed to the request subject"))
+	case errors.Is(err, context.Canceled):
+		return helper.RespondWithErrorGRPC(ctx, codes.Canceled, "request canceled", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return helper.RespondWithErrorGRPC(ctx, codes.DeadlineExceeded, "deadline exceeded", err)
+	default:
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "broker error", err,
+			helper.ResourceInfo("subject", subject, err.Error()))
+	}
+}
+
+// limitExceeded сообщает клиенту, какой лимит превышен и когда запрос можно
+// повторить
+func limitExceeded(ctx context.Context, err *limits.Error) error {
+	details := []protoadapt.MessageV1{helper.QuotaFailure(err.Key, err.Limit)}
+	if err.RetryAfter > 0 {
+		details = append(details, helper.RetryInfo(err.RetryAfter))
+	}
+	return helper.RespondWithErrorGRPC(ctx, codes.ResourceExhausted, "limit exceeded", err, details...)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/store"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBrokerError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{"Брокер закрыт", subpub.ErrClosed, codes.Unavailable},
+		{"Некорректная тема", fmt.Errorf("%w: %q", subpub.ErrInvalidSubject, "a.*"), codes.InvalidArgument},
+		{"Сообщение нельзя сохранить", subpub.ErrNotPersistable, codes.InvalidArgument},
+		{"Сообщение больше записи журнала", fmt.Errorf("subpub: %w", store.ErrRecordTooLarge), codes.InvalidArgument},
+		{"Медленный подписчик", subpub.ErrSlowConsumer, codes.ResourceExhausted},
+		{"Превышен лимит", subpub.ErrQuotaExceeded, codes.ResourceExhausted},
+		{"Нет журнала", subpub.ErrNoStore, codes.FailedPrecondition},
+		{"Никто не ответит", subpub.ErrNoResponders, codes.NotFound},
+		{"Паники обработчика", subpub.ErrTooManyPanics, codes.Internal},
+		{"Неизвестная ошибка", fmt.Errorf("disk failure"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := brokerError(context.Background(), "key", "orders", tt.err)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+// TestPublishClosed проверяет, что публикация в закрытый брокер не выглядит
+// как ошибка клиента
+func TestPublishClosed(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+	require.NoError(t, pubSub.Close(context.Background()))
+
+	_, err := client.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: "a"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = client.Publish(context.Background(), &pb.PublishRequest{Key: "orders.*", Data: "a"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestPublishInvalidSubject(t *testing.T) {
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub()))
+
+	_, err := client.Publish(context.Background(), &pb.PublishRequest{Key: "orders.*", Data: "a"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/imhasandl/vk-internship/auth"
+	"github.com/imhasandl/vk-internship/limits"
+	pb "github.com/imhasandl/vk-internship/protos"
+	"google.golang.org/grpc/peer"
+)
+
+// WithLimiter включает лимиты частоты публикаций, числа подписок и размера
+// сообщений. Превышение лимита возвращает ResourceExhausted.
+func WithLimiter(l *limits.Limiter) Option {
+	return func(s *apiConfig) {
+		s.limiter = l
+	}
+}
+
+// clientKey возвращает клиента, к которому применяются лимиты: имя
+// проверенного клиента, а для анонимных - адрес, с которого пришел запрос
+func clientKey(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok && id.Name != "" {
+		return id.Name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// allowPublish проверяет лимиты публикации
+func (s *apiConfig) allowPublish(ctx context.Context, req *pb.PublishRequest) error {
+	if s.limiter == nil {
+		return nil
+	}
+	size := len(req.Data)
+	if len(req.Payload) > 0 {
+		size = len(req.Payload)
+	}
+	if err := s.limiter.AllowPublish(clientKey(ctx), req.Key, size); err != nil {
+		return brokerError(ctx, "key", req.Key, err)
+	}
+	return nil
+}
+
+// acquireSubscription учитывает подписку клиента в лимите подписок
+func (s *apiConfig) acquireSubscription(ctx context.Context, subject string) (func(), error) {
+	if s.limiter == nil {
+		return func() {}, nil
+	}
+	release, err := s.limiter.AcquireSubscription(clientKey(ctx))
+	if err != nil {
+		return nil, brokerError(ctx, "key", subject, err)
+	}
+	return release, nil
+}
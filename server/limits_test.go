@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/vk-internship/limits"
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimits(t *testing.T) {
+	limiter, err := limits.New(limits.Config{
+		MaxMessageBytes: 8,
+		Client: limits.ClientLimits{
+			Publish:          limits.Rate{PerSecond: 1, Burst: 2},
+			MaxSubscriptions: 1,
+		},
+	})
+	require.NoError(t, err)
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub(), WithLimiter(limiter)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "orders", Payload: []byte("too large")})
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1, "Повтор не поможет, поэтому без RetryInfo")
+
+	for i := 0; i < 2; i++ {
+		_, err = client.Publish(ctx, &pb.PublishRequest{Key: "orders", Data: "a"})
+		require.NoError(t, err)
+	}
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "orders", Data: "a"})
+	st = status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	assert.Positive(t, retry.RetryDelay.AsDuration())
+
+	// Вторая одновременная подписка клиента отклоняется
+	_, err = client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders"})
+	require.NoError(t, err)
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+	second, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "payments"})
+	require.NoError(t, err)
+	_, err = second.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// После завершения первой подписки лимит освобождается: новая подписка
+	// ждет сообщений, пока не истечет ее дедлайн
+	cancel()
+	assert.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders"})
+		if err != nil {
+			return false
+		}
+		_, err = stream.Recv()
+		return status.Code(err) == codes.DeadlineExceeded
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/vk-internship/auth"
+	"github.com/imhasandl/vk-internship/helper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// requestIDHeader - метаданные с идентификатором запроса. Если клиент его
+// не передал, сервер создает новый и возвращает в заголовках ответа.
+const requestIDHeader = "x-request-id"
+
+// WithLogger задает логгер сервера. По умолчанию используется slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *apiConfig) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// requestContext добавляет в контекст логгер запроса с его идентификатором,
+// методом, адресом клиента и темой
+func (s *apiConfig) requestContext(ctx context.Context, method, subject string) context.Context {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 {
+			requestID = values[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	// Вне gRPC сервера (например, в тестах) заголовки отправить некуда
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+	attrs := []any{
+		slog.String("request_id", requestID),
+		slog.String("method", method),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("peer", p.Addr.String()))
+	}
+	if id, ok := auth.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("client", id.Name))
+	}
+	if subject != "" {
+		attrs = append(attrs, slog.String("subject", subject))
+	}
+	return helper.WithLogger(ctx, s.logger.With(attrs...))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Сервер возвращает идентификатор запроса и пишет его в лог вместе с темой и адресом клиента
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub(), WithLogger(logger)))
+
+	var header metadata.MD
+	_, err := client.Publish(context.Background(), &pb.PublishRequest{Key: "orders"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(requestIDHeader), 1)
+	assert.NotEmpty(t, header.Get(requestIDHeader)[0])
+
+	// Идентификатор клиента сохраняется
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDHeader, "client-request")
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{
+		Key:   "orders",
+		Start: pb.StartPosition_START_POSITION_TIME,
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+	_, err = stream.Recv()
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	assert.Equal(t, "start_time", badRequest.FieldViolations[0].Field)
+	assert.Equal(t, []string{"client-request"}, header.Get(requestIDHeader))
+
+	logs := buf.String()
+	assert.Contains(t, logs, "request_id=client-request")
+	assert.Contains(t, logs, "method=Subscribe")
+	assert.Contains(t, logs, "subject=orders")
+	assert.Contains(t, logs, "peer=")
+	assert.Contains(t, logs, `msg="invalid start position"`)
+}
+
+// Отключение медленного подписчика сообщает, какая подписка исчерпала ресурс
+func TestSlowConsumerDetails(t *testing.T) {
+	server := NewServer("test-port", subpub.NewSubPub())
+	err := server.subscriptionEnded(context.Background(), "orders", slowSubscription{})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ResourceInfo)
+	require.True(t, ok)
+	assert.Equal(t, "subscription", info.ResourceType)
+	assert.Equal(t, "orders", info.ResourceName)
+}
+
+type slowSubscription struct {
+	subpub.Subscription
+}
+
+func (slowSubscription) Err() error {
+	return subpub.ErrSlowConsumer
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/imhasandl/vk-internship/helper"
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"google.golang.org/grpc/codes"
+)
+
+// defaultRequestTimeout - сколько Request ждет ответов, если клиент не задал timeout
+const defaultRequestTimeout = 5 * time.Second
+
+// Request публикует запрос и возвращает ответы подписчиков. Подписчики
+// отвечают публикацией в тему из заголовка reply-to.
+func (s *apiConfig) Request(ctx context.Context, req *pb.RequestRequest) (*pb.RequestResponse, error) {
+	if req.Message == nil {
+		return nil, helper.RespondWithErrorGRPC(s.requestContext(ctx, "Request", ""), codes.InvalidArgument,
+			"message is required", nil, helper.BadRequest("message", "message is required"))
+	}
+	ctx = s.requestContext(ctx, "Request", req.Message.Key)
+
+	if err := s.unavailable(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkPublish(ctx, req.Message); err != nil {
+		return nil, err
+	}
+
+	timeout := defaultRequestTimeout
+	if req.Timeout != nil {
+		err := req.Timeout.CheckValid()
+		if err == nil && req.Timeout.AsDuration() <= 0 {
+			err = errors.New("timeout must be positive")
+		}
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid timeout", err,
+				helper.BadRequest("timeout", err.Error()))
+		}
+		timeout = req.Timeout.AsDuration()
+	}
+
+	n := int(req.MaxReplies)
+	if n == 0 {
+		n = 1
+	}
+
+	msg := publishMessage(req.Message)
+	msg.Headers = subpub.InjectTrace(incomingTrace(ctx), msg.Headers)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	replies, err := s.PubSub.RequestMany(waitCtx, msg, n)
+	if err != nil {
+		return nil, brokerError(ctx, "message.key", req.Message.Key, err)
+	}
+
+	resp := &pb.RequestResponse{Replies: make([]*pb.Event, 0, len(replies))}
+	for _, reply := range replies {
+		if event, ok := s.newEvent(ctx, reply); ok {
+			resp.Replies = append(resp.Replies, event)
+		}
+	}
+	return resp, nil
+}
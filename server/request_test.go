@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRequest(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	// Отвечающий внутри процесса
+	_, err := pubSub.SubscribeMsg("inventory.eu", "", func(msg *subpub.Message) {
+		require.NoError(t, pubSub.Reply(msg, fmt.Sprintf("eu:%s", msg.Data)))
+	})
+	require.NoError(t, err)
+
+	// Отвечающий через gRPC: получает reply-to в заголовках и публикует ответ
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "inventory.*"})
+	require.NoError(t, err)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			client.Publish(ctx, &pb.PublishRequest{Key: event.Headers[subpub.ReplyToHeader], Data: "grpc:" + event.Data})
+		}
+	}()
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+
+	tests := []struct {
+		name        string
+		req         *pb.RequestRequest
+		wantCode    codes.Code
+		wantReplies []string
+	}{
+		{
+			name:        "Первый ответ",
+			req:         &pb.RequestRequest{Message: &pb.PublishRequest{Key: "inventory.us", Data: "sku"}},
+			wantReplies: []string{"grpc:sku"},
+		},
+		{
+			name: "Сбор ответов",
+			req: &pb.RequestRequest{
+				Message:    &pb.PublishRequest{Key: "inventory.eu", Payload: []byte("sku")},
+				MaxReplies: 2,
+				Timeout:    durationpb.New(time.Second),
+			},
+			wantReplies: []string{"eu:sku", "grpc:sku"},
+		},
+		{
+			name:     "Никто не подписан",
+			req:      &pb.RequestRequest{Message: &pb.PublishRequest{Key: "payments", Data: "a"}},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "Ответов меньше, чем ждали",
+			req: &pb.RequestRequest{
+				Message:    &pb.PublishRequest{Key: "inventory.eu", Data: "a"},
+				MaxReplies: 3,
+				Timeout:    durationpb.New(100 * time.Millisecond),
+			},
+			wantReplies: []string{"eu:a", "grpc:a"},
+		},
+		{
+			name:     "Без сообщения",
+			req:      &pb.RequestRequest{},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Отрицательный timeout",
+			req: &pb.RequestRequest{
+				Message: &pb.PublishRequest{Key: "inventory.eu", Data: "a"},
+				Timeout: durationpb.New(-time.Second),
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Request(context.Background(), tt.req)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, event := range resp.Replies {
+				got = append(got, event.Data)
+			}
+			assert.ElementsMatch(t, tt.wantReplies, got)
+		})
+	}
+}
+
+func TestPublishStripsReplyTo(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	client := startTestServer(t, NewServer("test-port", pubSub))
+
+	got := make(chan *subpub.Message, 2)
+	_, err := pubSub.SubscribeMsg("orders", "", func(msg *subpub.Message) {
+		got <- msg
+		// Обработчик запросов не должен отвечать в тему, выбранную клиентом
+		assert.ErrorIs(t, pubSub.Reply(msg, "ok"), subpub.ErrNoReplyTo)
+	})
+	require.NoError(t, err)
+
+	headers := map[string]string{subpub.ReplyToHeader: "billing.charge", "trace": "1"}
+	_, err = client.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: "a", Headers: headers})
+	require.NoError(t, err)
+	_, err = client.PublishBatch(context.Background(), &pb.PublishBatchRequest{
+		Messages: []*pb.PublishRequest{{Key: "orders", Data: "b", Headers: headers}},
+	})
+	require.NoError(t, err)
+
+	for range 2 {
+		select {
+		case msg := <-got:
+			assert.Equal(t, map[string]string{"trace": "1"}, msg.Headers)
+		case <-time.After(time.Second):
+			t.Fatal("сообщение не доставлено")
+		}
+	}
+}
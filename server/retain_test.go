@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetained(t *testing.T) {
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.Publish(ctx, &pb.PublishRequest{Key: "config.eu", Data: "v1", Retain: true})
+	require.NoError(t, err)
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "config.eu", Data: "v2", Retain: true})
+	require.NoError(t, err)
+
+	// Новый подписчик сразу получает последнее сохраненное значение
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "config.*"})
+	require.NoError(t, err)
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "v2", event.Data)
+	assert.True(t, event.Retained)
+
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "config.eu", Data: "v3"})
+	require.NoError(t, err)
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "v3", event.Data)
+	assert.False(t, event.Retained)
+
+	resp, err := client.ClearRetained(ctx, &pb.ClearRetainedRequest{Key: "config.>"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), resp.Cleared)
+
+	// После очистки новый подписчик получает только новые сообщения
+	stream, err = client.Subscribe(ctx, &pb.SubscribeRequest{Key: "config.eu"})
+	require.NoError(t, err)
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "config.eu", Data: "v4"})
+	require.NoError(t, err)
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "v4", event.Data)
+	assert.False(t, event.Retained)
+}
+
+func TestClearRetainedInvalidKey(t *testing.T) {
+	client := startTestServer(t, NewServer("test-port", subpub.NewSubPub()))
+
+	_, err := client.ClearRetained(context.Background(), &pb.ClearRetainedRequest{Key: "config..eu"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
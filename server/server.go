@@ -2,62 +2,343 @@ package server
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/imhasandl/vk-internship/auth"
 	"github.com/imhasandl/vk-internship/helper"
+	"github.com/imhasandl/vk-internship/limits"
 	pb "github.com/imhasandl/vk-internship/protos"
 	"github.com/imhasandl/vk-internship/subpub"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type apiConfig struct {
 	pb.UnimplementedSubPubServer
 	Port string
 	PubSub *subpub.PubSub  
+
+	ackWait    time.Duration
+	maxDeliver uint32
+	maxPending uint32
+	codec      subpub.Codec
+	tracer     trace.Tracer // nil, если трассировка выключена
+	logger     *slog.Logger
+	authorizer auth.Authorizer // nil, если права не проверяются
+	limiter    *limits.Limiter // nil, если лимиты выключены
+
+	shuttingDown atomic.Bool
+}
+
+// Option настраивает сервер при создании
+type Option func(*apiConfig)
+
+// WithAckWait задает время ожидания подтверждения в Consume по умолчанию
+func WithAckWait(d time.Duration) Option {
+	return func(s *apiConfig) {
+		s.ackWait = d
+	}
+}
+
+// WithMaxDeliver задает число попыток доставки в Consume по умолчанию, 0 - без ограничения
+func WithMaxDeliver(n uint32) Option {
+	return func(s *apiConfig) {
+		s.maxDeliver = n
+	}
+}
+
+// WithMaxAckPending задает, сколько событий в Consume по умолчанию может
+// ждать подтверждения, 0 - без ограничения
+func WithMaxAckPending(n uint32) Option {
+	return func(s *apiConfig) {
+		s.maxPending = n
+	}
+}
+
+// WithCodec задает кодек для сообщений, опубликованных внутри процесса
+// значениями, отличными от строк и байт. По умолчанию - JSON.
+func WithCodec(codec subpub.Codec) Option {
+	return func(s *apiConfig) {
+		s.codec = codec
+	}
 }
 
 // NewServer создает новый экземпляр сервера.
-func NewServer(port string, pubsub *subpub.PubSub) *apiConfig {
-	return &apiConfig{
+func NewServer(port string, pubsub *subpub.PubSub, opts ...Option) *apiConfig {
+	s := &apiConfig{
 		Port: port,
 		PubSub: pubsub,
+		ackWait:    defaultAckWait,
+		maxDeliver: defaultMaxDeliver,
+		maxPending: defaultMaxAckPending,
+		codec:      subpub.JSONCodec,
+		logger:     slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func (s *apiConfig) Subscribe(req *pb.SubscribeRequest, stream pb.SubPub_SubscribeServer) error {
-	msgChan := make(chan string)
+	// Контекст отменяется при любом выходе из Subscribe, чтобы обработчик
+	// подписки не ждал вечно отправки в поток, который уже не читают
+	ctx, cancel := context.WithCancel(s.requestContext(stream.Context(), "Subscribe", req.Key))
+	defer cancel()
+
+	msgChan := make(chan *subpub.Message)
 
-	handler := func(msg interface{}) {
-		if data, ok := msg.(string); ok {
-			msgChan <- data
+	handler := func(msg *subpub.Message) {
+		select {
+		case msgChan <- msg:
+		case <-ctx.Done():
 		}
 	}
 
-	subscription, err := s.PubSub.Subscribe(req.Key, handler)
+	subscription, err := s.subscribe(ctx, req, handler, overflowPolicy)
 	if err != nil {
-		return helper.RespondWithErrorGRPC(context.Background(), codes.InvalidArgument, "invalid argument", err)
+		return err
 	}
 
 	defer subscription.Unsubscribe()
 
-	ctx := stream.Context()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case data := <-msgChan:
-			if err := stream.Send(&pb.Event{Data: data}); err != nil {
+		case <-subscription.Done():
+			return s.subscriptionEnded(ctx, req.Key, subscription)
+		case msg := <-msgChan:
+			if err := s.sendEvent(ctx, stream, msg); err != nil {
 				return helper.RespondWithErrorGRPC(ctx, codes.Internal, "Failed to send message", err)
 			}
 		}
 	}
 }
 
-func (s *apiConfig) Publish(ctx context.Context, req *pb.PublishRequest) (*emptypb.Empty, error) {
-	err := s.PubSub.Publish(req.Key, req.Data)
+// subscribe подписывает обработчик согласно запросу клиента. policy
+// переводит политику переполнения из запроса в политику брокера.
+func (s *apiConfig) subscribe(ctx context.Context, req *pb.SubscribeRequest, handler subpub.MsgHandler,
+	policy func(pb.OverflowPolicy) (subpub.OverflowPolicy, error)) (subpub.Subscription, error) {
+	if err := s.unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(ctx, auth.ActionSubscribe, req.Key); err != nil {
+		return nil, err
+	}
+
+	start, err := startPosition(req)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid start position", err,
+			helper.BadRequest(startField(req), err.Error()))
+	}
+
+	overflow, err := policy(req.Overflow)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid argument", err)
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid overflow policy", err,
+			helper.BadRequest("overflow", err.Error()))
+	}
+
+	release, err := s.acquireSubscription(ctx, req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := s.PubSub.SubscribeMsg(req.Key, req.Group, handler,
+		subpub.WithStartPosition(start), subpub.WithOverflowPolicy(overflow))
+	if err != nil {
+		release()
+		return nil, brokerError(ctx, "key", req.Key, err)
+	}
+	// Подписка освобождает лимит, когда брокер перестает ей доставлять
+	go func() {
+		<-subscription.Done()
+		release()
+	}()
+	helper.Logger(ctx).Debug("subscribed", slog.String("group", req.Group))
+	return subscription, nil
+}
+
+// startField возвращает поле запроса, задающее позицию журнала
+func startField(req *pb.SubscribeRequest) string {
+	if req.Start == pb.StartPosition_START_POSITION_TIME {
+		return "start_time"
+	}
+	return "start"
+}
+
+// subscriptionEnded формирует ответ, когда брокер сам завершил подписку
+func (s *apiConfig) subscriptionEnded(ctx context.Context, subject string, subscription subpub.Subscription) error {
+	// Брокер отключил подписчика, например не успевавшего разбирать очередь
+	if err := subscription.Err(); err != nil {
+		return brokerError(ctx, "key", subject, err)
+	}
+	// Брокер дообработал очередь подписчика при остановке сервера
+	return s.unavailable(ctx)
+}
+
+// newEvent переводит сообщение брокера в событие для клиента. Значения,
+// опубликованные не строкой и не байтами, кодируются кодеком сервера.
+func (s *apiConfig) newEvent(ctx context.Context, msg *subpub.Message) (*pb.Event, bool) {
+	contentType := msg.ContentType
+
+	var payload []byte
+	switch d := msg.Data.(type) {
+	case string:
+		payload = []byte(d)
+	case []byte:
+		payload = d
+	default:
+		encoded, err := s.codec.Marshal(d)
+		if err != nil {
+			helper.Logger(ctx).Warn("skipping message that cannot be encoded",
+				slog.String("subject", msg.Subject),
+				slog.Uint64("seq", msg.Seq),
+				slog.Any("error", err))
+			return nil, false
+		}
+		payload = encoded
+		if contentType == "" {
+			contentType = s.codec.ContentType()
+		}
+	}
+
+	event := &pb.Event{
+		Sequence:    msg.Seq,
+		Key:         msg.Subject,
+		Payload:     payload,
+		Headers:     msg.Headers,
+		ContentType: contentType,
+		Id:          msg.ID.String(),
+		PublishedAt: timestamppb.New(msg.Time),
+		Retained:    msg.Retain,
+	}
+	// Старые клиенты читают только строковое поле, а строка в protobuf
+	// обязана быть корректным UTF-8
+	if utf8.Valid(payload) {
+		event.Data = string(payload)
+	}
+	return event, true
+}
+
+// startPosition переводит позицию из запроса в позицию журнала
+func startPosition(req *pb.SubscribeRequest) (subpub.StartPosition, error) {
+	switch req.Start {
+	case pb.StartPosition_START_POSITION_LATEST:
+		return subpub.StartLatest(), nil
+	case pb.StartPosition_START_POSITION_EARLIEST:
+		return subpub.StartEarliest(), nil
+	case pb.StartPosition_START_POSITION_SEQUENCE:
+		return subpub.StartAtSequence(req.StartSequence), nil
+	case pb.StartPosition_START_POSITION_TIME:
+		if err := req.StartTime.CheckValid(); err != nil {
+			return subpub.StartPosition{}, err
+		}
+		return subpub.StartAtTime(req.StartTime.AsTime()), nil
+	default:
+		return subpub.StartPosition{}, errors.New("unknown start position")
+	}
+}
+
+// overflowPolicy переводит политику из запроса в политику брокера. Сетевой
+// подписчик никогда не получает BlockPublisher: иначе клиент, переставший
+// читать поток, останавливал бы Publish всех остальных.
+func overflowPolicy(p pb.OverflowPolicy) (subpub.OverflowPolicy, error) {
+	switch p {
+	case pb.OverflowPolicy_OVERFLOW_POLICY_DEFAULT, pb.OverflowPolicy_OVERFLOW_POLICY_DROP_OLDEST:
+		return subpub.DropOldest, nil
+	case pb.OverflowPolicy_OVERFLOW_POLICY_DROP_NEWEST:
+		return subpub.DropNewest, nil
+	case pb.OverflowPolicy_OVERFLOW_POLICY_DISCONNECT:
+		return subpub.Disconnect, nil
+	default:
+		return 0, errors.New("unknown overflow policy")
+	}
+}
+
+func (s *apiConfig) Publish(ctx context.Context, req *pb.PublishRequest) (*emptypb.Empty, error) {
+	ctx = s.requestContext(ctx, "Publish", req.Key)
+
+	if err := s.unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPublish(ctx, req); err != nil {
+		return nil, err
+	}
+
+	msg := publishMessage(req)
+	// Контекст трассировки издателя едет вместе с сообщением в заголовках
+	msg.Headers = subpub.InjectTrace(incomingTrace(ctx), msg.Headers)
+
+	if err := s.PubSub.PublishMsg(msg); err != nil {
+		return nil, brokerError(ctx, "key", req.Key, err)
 	}
 
 	return &emptypb.Empty{}, nil
-}
\ No newline at end of file
+}
+
+// checkPublish проверяет, может ли клиент опубликовать сообщение
+func (s *apiConfig) checkPublish(ctx context.Context, req *pb.PublishRequest) error {
+	// Системные темы заполняет только сам сервер
+	if subpub.IsSystemSubject(req.Key) {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "subject is reserved", nil,
+			helper.BadRequest("key", "subjects starting with \"$\" are reserved for the server"))
+	}
+	if err := s.authorize(ctx, auth.ActionPublish, req.Key); err != nil {
+		return err
+	}
+	return s.allowPublish(ctx, req)
+}
+
+// publishMessage переводит запрос клиента в сообщение брокера. Бинарные данные
+// важнее строковых, строка остается для клиентов, которые не знают о payload.
+// Заголовок reply-to задает только сервер в Request.
+func publishMessage(req *pb.PublishRequest) *subpub.Message {
+	headers := req.Headers
+	if _, ok := headers[subpub.ReplyToHeader]; ok {
+		headers = maps.Clone(headers)
+		delete(headers, subpub.ReplyToHeader)
+	}
+	msg := &subpub.Message{
+		Subject:     req.Key,
+		Headers:     headers,
+		ContentType: req.ContentType,
+		Data:        req.Data,
+		Retain:      req.Retain,
+	}
+	if len(req.Payload) > 0 {
+		msg.Data = req.Payload
+	}
+	return msg
+}
+
+// ClearRetained удаляет сохраненные значения тем, совпадающих с ключом
+func (s *apiConfig) ClearRetained(ctx context.Context, req *pb.ClearRetainedRequest) (*pb.ClearRetainedResponse, error) {
+	ctx = s.requestContext(ctx, "ClearRetained", req.Key)
+
+	if err := s.unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	// Очистка меняет то, что получат подписчики, как и публикация
+	if err := s.authorize(ctx, auth.ActionPublish, req.Key); err != nil {
+		return nil, err
+	}
+
+	n, err := s.PubSub.ClearRetained(req.Key)
+	if err != nil {
+		return nil, brokerError(ctx, "key", req.Key, err)
+	}
+	helper.Logger(ctx).Debug("retained messages cleared", slog.Int("cleared", n))
+
+	return &pb.ClearRetainedResponse{Cleared: uint32(n)}, nil
+}
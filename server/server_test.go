@@ -2,13 +2,20 @@ package server
 
 import (
     "context"
+    "sync/atomic"
     "testing"
     "time"
 
     "github.com/imhasandl/vk-internship/protos"
+    "github.com/imhasandl/vk-internship/store"
     "github.com/imhasandl/vk-internship/subpub"
     "github.com/stretchr/testify/assert"
     "github.com/stretchr/testify/mock"
+    "github.com/stretchr/testify/require"
+    "go.uber.org/goleak"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
 )
 
 // Мок для SubPub_SubscribeServer
@@ -27,6 +34,19 @@ func (m *mockSubscribeServer) Context() context.Context {
     return m.ctx
 }
 
+// eventLike сравнивает событие с ожидаемым без учета идентификатора
+// и времени публикации, которые назначает брокер
+func eventLike(want *protos.Event) interface{} {
+    return mock.MatchedBy(func(got *protos.Event) bool {
+        if got.Id == "" || got.PublishedAt == nil {
+            return false
+        }
+        got = proto.Clone(got).(*protos.Event)
+        got.Id, got.PublishedAt = "", nil
+        return proto.Equal(want, got)
+    })
+}
+
 // Тест для метода Publish
 func TestPublish(t *testing.T) {
     // Тестовые случаи
@@ -46,7 +66,19 @@ func TestPublish(t *testing.T) {
             name:    "Пустой ключ",
             key:     "",
             data:    "test message",
-            wantErr: false,
+            wantErr: true,
+        },
+        {
+            name:    "Недопустимые символы в ключе",
+            key:     "test key",
+            data:    "test message",
+            wantErr: true,
+        },
+        {
+            name:    "Системная тема",
+            key:     "$DLQ.test-topic",
+            data:    "test message",
+            wantErr: true,
         },
         {
             name:    "Пустые данные",
@@ -95,14 +127,14 @@ func TestSubscribe(t *testing.T) {
             expectMsg: true,
         },
         {
-            name:      "Пустой ключ",
-            key:       "",
-            message:   "сообщение для пустого ключа",
+            name:      "Иерархический ключ",
+            key:       "orders.eu.created",
+            message:   "сообщение для иерархического ключа",
             expectMsg: true,
         },
         {
-            name:      "Специальные символы в ключе",
-            key:       "test-key!@#$%^&*()",
+            name:      "Допустимые спецсимволы в ключе",
+            key:       "test_key-1",
             message:   "сообщение для ключа со спецсимволами",
             expectMsg: true,
         },
@@ -120,9 +152,12 @@ func TestSubscribe(t *testing.T) {
             }
             
             // Настраиваем ожидание вызова Send с нашим сообщением
-            mockStream.On("Send", &protos.Event{
-                Data: tt.message,
-            }).Return(nil)
+            mockStream.On("Send", eventLike(&protos.Event{
+                Data:     tt.message,
+                Payload:  []byte(tt.message),
+                Sequence: 1,
+                Key:      tt.key,
+            })).Return(nil)
 
             // Создаем экземпляр PubSub и сервера
             pubSub := subpub.NewSubPub()
@@ -158,4 +193,374 @@ func TestSubscribe(t *testing.T) {
             mockStream.AssertExpectations(t)
         })
     }
-}
\ No newline at end of file
+}
+// Тест подписки через gRPC на шаблон темы
+func TestSubscribeWildcard(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    mockStream := &mockSubscribeServer{
+        ctx: ctx,
+    }
+    mockStream.On("Send", eventLike(&protos.Event{
+        Data:     "created",
+        Payload:  []byte("created"),
+        Sequence: 1,
+        Key:      "orders.eu.created",
+    })).Return(nil).Once()
+
+    pubSub := subpub.NewSubPub()
+    server := NewServer("test-port", pubSub)
+
+    errCh := make(chan error)
+    go func() {
+        errCh <- server.Subscribe(&protos.SubscribeRequest{
+            Key: "orders.>",
+        }, mockStream)
+    }()
+
+    // Даем время на установку подписки
+    time.Sleep(50 * time.Millisecond)
+
+    assert.NoError(t, pubSub.Publish("orders.eu.created", "created"))
+    assert.NoError(t, pubSub.Publish("payments.eu.created", "ignored"))
+
+    // Даем время на обработку сообщения
+    time.Sleep(50 * time.Millisecond)
+
+    cancel()
+    assert.ErrorIs(t, <-errCh, context.Canceled)
+
+    mockStream.AssertExpectations(t)
+}
+
+// Тест доставки сообщений, опубликованных внутри процесса не строкой
+func TestSubscribeEncoded(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    mockStream := &mockSubscribeServer{
+        ctx: ctx,
+    }
+    mockStream.On("Send", eventLike(&protos.Event{
+        Data:     "raw",
+        Payload:  []byte("raw"),
+        Sequence: 1,
+        Key:      "orders",
+    })).Return(nil).Once()
+    mockStream.On("Send", eventLike(&protos.Event{
+        Data:        `{"id":7}`,
+        Payload:     []byte(`{"id":7}`),
+        ContentType: "application/json",
+        Sequence:    2,
+        Key:         "orders",
+    })).Return(nil).Once()
+
+    pubSub := subpub.NewSubPub()
+    server := NewServer("test-port", pubSub)
+
+    errCh := make(chan error)
+    go func() {
+        errCh <- server.Subscribe(&protos.SubscribeRequest{
+            Key: "orders",
+        }, mockStream)
+    }()
+
+    // Даем время на установку подписки
+    time.Sleep(50 * time.Millisecond)
+
+    assert.NoError(t, pubSub.Publish("orders", []byte("raw")))
+    assert.NoError(t, pubSub.Publish("orders", map[string]int{"id": 7}))
+
+    // Даем время на обработку сообщений
+    time.Sleep(50 * time.Millisecond)
+
+    cancel()
+    assert.ErrorIs(t, <-errCh, context.Canceled)
+
+    mockStream.AssertExpectations(t)
+}
+
+// Тест подписчиков одной очереди через gRPC
+func TestSubscribeGroup(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    pubSub := subpub.NewSubPub()
+    server := NewServer("test-port", pubSub)
+
+    streams := make([]*mockSubscribeServer, 2)
+    errCh := make(chan error, len(streams))
+    for i := range streams {
+        streams[i] = &mockSubscribeServer{
+            ctx: ctx,
+        }
+        streams[i].On("Send", mock.Anything).Return(nil).Times(2)
+
+        go func(stream *mockSubscribeServer) {
+            errCh <- server.Subscribe(&protos.SubscribeRequest{
+                Key:   "jobs",
+                Group: "workers",
+            }, stream)
+        }(streams[i])
+    }
+
+    // Даем время на установку подписок
+    time.Sleep(50 * time.Millisecond)
+
+    for _, data := range []string{"1", "2", "3", "4"} {
+        assert.NoError(t, pubSub.Publish("jobs", data))
+    }
+
+    // Даем время на обработку сообщений
+    time.Sleep(50 * time.Millisecond)
+
+    cancel()
+    for range streams {
+        assert.ErrorIs(t, <-errCh, context.Canceled)
+    }
+
+    for _, stream := range streams {
+        stream.AssertExpectations(t)
+    }
+}
+
+// Тест подписки через gRPC с чтением истории из журнала
+func TestSubscribeReplay(t *testing.T) {
+    tests := []struct {
+        name     string
+        withLog  bool
+        req      *protos.SubscribeRequest
+        want     []uint64
+        wantCode codes.Code
+    }{
+        {
+            name:    "С начала журнала",
+            withLog: true,
+            req: &protos.SubscribeRequest{
+                Key:   "orders",
+                Start: protos.StartPosition_START_POSITION_EARLIEST,
+            },
+            want: []uint64{1, 2, 3},
+        },
+        {
+            name:    "С номера",
+            withLog: true,
+            req: &protos.SubscribeRequest{
+                Key:           "orders",
+                Start:         protos.StartPosition_START_POSITION_SEQUENCE,
+                StartSequence: 2,
+            },
+            want: []uint64{2, 3},
+        },
+        {
+            name:    "Некорректное время",
+            withLog: true,
+            req: &protos.SubscribeRequest{
+                Key:   "orders",
+                Start: protos.StartPosition_START_POSITION_TIME,
+            },
+            wantCode: codes.InvalidArgument,
+        },
+        {
+            name: "Журнал не подключен",
+            req: &protos.SubscribeRequest{
+                Key:   "orders",
+                Start: protos.StartPosition_START_POSITION_EARLIEST,
+            },
+            wantCode: codes.FailedPrecondition,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+
+            var opts []subpub.Option
+            if tt.withLog {
+                st, err := store.Open(t.TempDir(), store.Options{})
+                require.NoError(t, err)
+                defer st.Close()
+                opts = append(opts, subpub.WithStore(st))
+            }
+            pubSub := subpub.NewSubPub(opts...)
+            server := NewServer("test-port", pubSub)
+
+            if tt.withLog {
+                require.NoError(t, pubSub.Publish("orders", "1"))
+                require.NoError(t, pubSub.Publish("orders", "2"))
+            }
+
+            var sent []uint64
+            mockStream := &mockSubscribeServer{
+                ctx: ctx,
+            }
+            mockStream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+                sent = append(sent, args.Get(0).(*protos.Event).Sequence)
+            }).Return(nil)
+
+            errCh := make(chan error)
+            go func() {
+                errCh <- server.Subscribe(tt.req, mockStream)
+            }()
+
+            if tt.wantCode != codes.OK {
+                assert.Equal(t, tt.wantCode, status.Code(<-errCh))
+                return
+            }
+
+            // Даем время на установку подписки
+            time.Sleep(50 * time.Millisecond)
+            require.NoError(t, pubSub.Publish("orders", "3"))
+            time.Sleep(50 * time.Millisecond)
+
+            cancel()
+            assert.ErrorIs(t, <-errCh, context.Canceled)
+            assert.Equal(t, tt.want, sent)
+        })
+    }
+}
+
+// Тест на утечку горутин: поток подписчика завершается посреди доставки,
+// пока издатель продолжает публиковать сообщения
+func TestSubscribeDisconnectLeak(t *testing.T) {
+    defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+    const queueSize = 4
+    pubSub := subpub.NewSubPub(subpub.WithQueueSize(queueSize))
+    server := NewServer("test-port", pubSub)
+
+    // Номер сообщения совпадает с числом публикаций, брокер без журнала
+    var published atomic.Uint64
+    stopPublishing := make(chan struct{})
+    publisherDone := make(chan struct{})
+    go func() {
+        defer close(publisherDone)
+        for n := uint64(1); ; n++ {
+            select {
+            case <-stopPublishing:
+                return
+            default:
+            }
+            if err := pubSub.Publish("load", "data"); err != nil {
+                return
+            }
+            published.Store(n)
+        }
+    }()
+
+    for i := 0; i < 100; i++ {
+        ctx, cancel := context.WithCancel(context.Background())
+
+        // Клиент перестает читать поток после первого сообщения:
+        // Send ждет, пока поток не будет закрыт
+        first := make(chan uint64, 1)
+        mockStream := &mockSubscribeServer{
+            ctx: ctx,
+        }
+        mockStream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+            first <- args.Get(0).(*protos.Event).Sequence
+            <-ctx.Done()
+        }).Return(context.Canceled).Once()
+
+        errCh := make(chan error)
+        go func() {
+            errCh <- server.Subscribe(&protos.SubscribeRequest{Key: "load"}, mockStream)
+        }()
+
+        var seq uint64
+        select {
+        case seq = <-first:
+        case <-time.After(time.Second):
+            t.Fatal("Подписчик не получил сообщение")
+        }
+
+        // Очередь подписчика заполнена, значит обработчик забрал из нее
+        // следующее сообщение и завис на передаче его в поток
+        require.Eventually(t, func() bool {
+            return published.Load() >= seq+queueSize+1
+        }, time.Second, time.Millisecond)
+
+        cancel()
+        assert.Error(t, <-errCh)
+    }
+
+    close(stopPublishing)
+    <-publisherDone
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    require.NoError(t, pubSub.Close(ctx), "Close не дождался горутин подписчиков")
+}
+
+// Клиент, переставший читать поток, не должен останавливать публикации
+func TestSubscribeOverflow(t *testing.T) {
+    tests := []struct {
+        name     string
+        overflow protos.OverflowPolicy
+        wantCode codes.Code
+    }{
+        {"По умолчанию вытесняются старые", protos.OverflowPolicy_OVERFLOW_POLICY_DEFAULT, codes.OK},
+        {"Вытесняются старые", protos.OverflowPolicy_OVERFLOW_POLICY_DROP_OLDEST, codes.OK},
+        {"Отбрасываются новые", protos.OverflowPolicy_OVERFLOW_POLICY_DROP_NEWEST, codes.OK},
+        {"Отключение", protos.OverflowPolicy_OVERFLOW_POLICY_DISCONNECT, codes.ResourceExhausted},
+        {"Неизвестная политика", protos.OverflowPolicy(42), codes.InvalidArgument},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := subpub.NewSubPub(subpub.WithQueueSize(4))
+            server := NewServer("test-port", pubSub)
+
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            release := make(chan struct{})
+            mockStream := &mockSubscribeServer{ctx: ctx}
+            mockStream.On("Send", mock.Anything).Run(func(mock.Arguments) {
+                <-release
+            }).Return(nil)
+
+            errCh := make(chan error, 1)
+            go func() {
+                errCh <- server.Subscribe(&protos.SubscribeRequest{Key: "load", Overflow: tt.overflow}, mockStream)
+            }()
+            if tt.wantCode == codes.InvalidArgument {
+                assert.Equal(t, tt.wantCode, status.Code(<-errCh))
+                return
+            }
+            // Даем время на установку подписки
+            time.Sleep(50 * time.Millisecond)
+
+            published := make(chan error, 1)
+            go func() {
+                for i := 0; i < 100; i++ {
+                    if err := pubSub.Publish("load", "data"); err != nil {
+                        published <- err
+                        return
+                    }
+                }
+                published <- nil
+            }()
+            select {
+            case err := <-published:
+                require.NoError(t, err)
+            case <-time.After(time.Second):
+                t.Fatal("Publish ждет подписчика, который не читает поток")
+            }
+            close(release)
+
+            if tt.wantCode == codes.OK {
+                cancel()
+            }
+            select {
+            case err := <-errCh:
+                if tt.wantCode != codes.OK {
+                    assert.Equal(t, tt.wantCode, status.Code(err))
+                }
+            case <-time.After(time.Second):
+                t.Fatal("Subscribe не завершился")
+            }
+        })
+    }
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/imhasandl/vk-internship/helper"
+	"google.golang.org/grpc/codes"
+)
+
+// errShuttingDown - причина отказа, пока сервер останавливается
+var errShuttingDown = errors.New("server shutting down")
+
+// Shutdown переводит сервер в режим остановки: новые публикации и подписки
+// отклоняются с кодом Unavailable, а брокер дообрабатывает очереди подписчиков
+// до дедлайна ctx. Потоки подписчиков после этого завершаются статусом
+// Unavailable "server shutting down".
+func (s *apiConfig) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	return s.PubSub.Close(ctx)
+}
+
+// unavailable возвращает ошибку для запросов, пришедших во время остановки
+func (s *apiConfig) unavailable(ctx context.Context) error {
+	if !s.shuttingDown.Load() {
+		return nil
+	}
+	return helper.RespondWithErrorGRPC(ctx, codes.Unavailable, errShuttingDown.Error(), nil)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShutdown(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	server := NewServer("test-port", pubSub)
+	client := startTestServer(t, server)
+
+	ctx := context.Background()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders"})
+	require.NoError(t, err)
+
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+
+	for _, data := range []string{"1", "2", "3"} {
+		_, err := client.Publish(ctx, &pb.PublishRequest{Key: "orders", Data: data})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, server.Shutdown(ctx))
+
+	// Подписчик получает все опубликованные сообщения, затем статус остановки
+	for _, want := range []string{"1", "2", "3"} {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, want, event.Data)
+	}
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "server shutting down", status.Convert(err).Message())
+
+	_, err = client.Publish(ctx, &pb.PublishRequest{Key: "orders", Data: "late"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	late, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders"})
+	require.NoError(t, err)
+	_, err = late.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	pubSub := subpub.NewSubPub()
+	server := NewServer("test-port", pubSub)
+
+	// Подписчик, который не успевает дообработать очередь к дедлайну
+	release := make(chan struct{})
+	defer close(release)
+	_, err := pubSub.Subscribe("orders", func(msg interface{}) {
+		<-release
+	})
+	require.NoError(t, err)
+	require.NoError(t, pubSub.Publish("orders", "slow"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+}
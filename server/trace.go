@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const tracerName = "github.com/imhasandl/vk-internship/server"
+
+// WithTracerProvider включает спаны отправки событий подписчикам
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *apiConfig) {
+		if tp != nil {
+			s.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// metadataCarrier позволяет читать контекст трассировки из метаданных gRPC
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// incomingTrace возвращает контекст трассировки запроса. Если спан уже начат
+// перехватчиком gRPC, используется он, иначе контекст читается из метаданных.
+func incomingTrace(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, metadataCarrier(md))
+}
+
+// sendEvent отправляет сообщение в поток подписчика в спане, продолжающем
+// трассу сообщения
+func (s *apiConfig) sendEvent(ctx context.Context, stream pb.SubPub_SubscribeServer, msg *subpub.Message) error {
+	event, ok := s.newEvent(ctx, msg)
+	if !ok {
+		return nil
+	}
+	if s.tracer == nil {
+		return stream.Send(event)
+	}
+
+	_, span := s.tracer.Start(msg.Context(), "subpub.send "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", msg.Subject),
+			attribute.String("messaging.message.id", event.Id),
+			attribute.Int64("subpub.sequence", int64(event.Sequence)),
+		),
+	)
+	defer span.End()
+
+	err := stream.Send(event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/imhasandl/vk-internship/protos"
+	"github.com/imhasandl/vk-internship/subpub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// Трасса издателя продолжается через брокер до отправки события подписчику
+func TestTracePropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	pubSub := subpub.NewSubPub(subpub.WithTracerProvider(tp))
+	client := startTestServer(t, NewServer("test-port", pubSub, WithTracerProvider(tp)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pb.SubscribeRequest{Key: "orders"})
+	require.NoError(t, err)
+
+	// Даем время на установку подписки
+	time.Sleep(50 * time.Millisecond)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	publishCtx := metadata.AppendToOutgoingContext(ctx, "traceparent", "00-"+traceID+"-"+spanID+"-01")
+	_, err = client.Publish(publishCtx, &pb.PublishRequest{Key: "orders", Data: "created"})
+	require.NoError(t, err)
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Contains(t, event.Headers["traceparent"], traceID, "Клиент может продолжить трассу")
+
+	require.Eventually(t, func() bool {
+		return len(exporter.GetSpans()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+		spans[span.Name] = span
+	}
+
+	enqueue := spans["subpub.enqueue orders"]
+	handle := spans["subpub.handle orders"]
+	send := spans["subpub.send orders"]
+	assert.Equal(t, spanID, enqueue.Parent.SpanID().String())
+	assert.True(t, enqueue.Parent.IsRemote())
+	assert.Equal(t, trace.SpanKindProducer, enqueue.SpanKind)
+	assert.Equal(t, enqueue.SpanContext.SpanID(), handle.Parent.SpanID())
+	assert.Equal(t, handle.SpanContext.SpanID(), send.Parent.SpanID())
+}
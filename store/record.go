@@ -0,0 +1,70 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	// recordHeaderSize - длина тела и контрольная сумма перед телом записи
+	recordHeaderSize = 8
+	// recordMetaSize - номер и время записи в начале тела
+	recordMetaSize = 16
+	// maxRecordSize ограничивает длину тела, чтобы мусор в заголовке
+	// не приводил к огромным аллокациям при восстановлении
+	maxRecordSize = 64 << 20
+)
+
+// ErrCorrupt возвращается для записи с неверной длиной или контрольной суммой
+var ErrCorrupt = errors.New("store: corrupt record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecord кодирует запись:
+//
+//	[4 длина тела][4 CRC-32C тела][8 номер][8 время, нс][данные]
+func encodeRecord(rec *Record) []byte {
+	buf := make([]byte, recordHeaderSize+recordMetaSize+len(rec.Data))
+	body := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint64(body[0:], rec.Seq)
+	binary.BigEndian.PutUint64(body[8:], uint64(rec.Time.UnixNano()))
+	copy(body[recordMetaSize:], rec.Data)
+
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(body)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(body, crcTable))
+	return buf
+}
+
+// readRecord читает одну запись и возвращает ее размер на диске.
+// Чистый конец файла возвращает io.EOF, незаконченная запись -
+// io.ErrUnexpectedEOF, поврежденная - ErrCorrupt.
+func readRecord(r io.Reader) (*Record, int, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:])
+	if size < recordMetaSize || size > maxRecordSize {
+		return nil, 0, ErrCorrupt
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, ErrCorrupt
+	}
+
+	return &Record{
+		Seq:  binary.BigEndian.Uint64(body[0:]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(body[8:]))),
+		Data: body[recordMetaSize:],
+	}, recordHeaderSize + int(size), nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bufio"
+	"container/heap"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// iterator последовательно читает записи сегментов одной темы
+type iterator struct {
+	subject  string
+	segments []segment
+	offset   int64 // смещение в первом сегменте, с которого начинается чтение
+	from     uint64
+
+	readers *readers
+	elem    *list.Element
+	current segment // сегмент, открытый в file
+	pos     int64   // смещение в file после последней прочитанной записи
+	file    *os.File
+	reader  *bufio.Reader
+}
+
+// readers ограничивает число сегментов, открытых на чтение. Слоты общие
+// для всех Scan журнала, а открытые сегменты одного Scan лежат в open.
+type readers struct {
+	slots chan struct{}
+	open  *list.List // итераторы с открытым сегментом, недавние в начале
+}
+
+// acquire занимает слот для итератора. Если свободных слотов нет, слот
+// переходит от итератора этого же Scan, который дольше всех не читали.
+// Ждет освобождения слота только Scan без открытых сегментов, поэтому
+// одновременные чтения не блокируют друг друга навсегда.
+func (r *readers) acquire(it *iterator) {
+	if r.open.Len() == 0 {
+		r.slots <- struct{}{}
+	} else {
+		select {
+		case r.slots <- struct{}{}:
+		default:
+			r.open.Back().Value.(*iterator).park()
+		}
+	}
+	it.elem = r.open.PushFront(it)
+}
+
+// next возвращает следующую запись или io.EOF, когда записи закончились
+func (it *iterator) next() (*Record, error) {
+	for {
+		if it.reader == nil {
+			if len(it.segments) == 0 {
+				return nil, io.EOF
+			}
+			if err := it.open(); err != nil {
+				return nil, err
+			}
+		}
+
+		rec, n, err := readRecord(it.reader)
+		if err != nil {
+			// Конец сегмента или запись, которую еще дописывают
+			it.closeFile()
+			continue
+		}
+		it.pos += int64(n)
+		it.readers.open.MoveToFront(it.elem)
+		if rec.Seq < it.from {
+			continue
+		}
+		rec.Subject = it.subject
+		return rec, nil
+	}
+}
+
+func (it *iterator) open() error {
+	it.readers.acquire(it)
+	f, err := os.Open(it.segments[0].path)
+	if err != nil {
+		it.release()
+		return fmt.Errorf("store: open segment: %w", err)
+	}
+	if it.offset > 0 {
+		if _, err := f.Seek(it.offset, io.SeekStart); err != nil {
+			f.Close()
+			it.release()
+			return fmt.Errorf("store: seek segment: %w", err)
+		}
+	}
+	it.current = it.segments[0]
+	it.pos = it.offset
+	it.offset = 0
+	it.segments = it.segments[1:]
+	it.file = f
+	it.reader = bufio.NewReader(f)
+	return nil
+}
+
+// park закрывает сегмент, не освобождая слот, и запоминает позицию,
+// с которой next откроет его снова
+func (it *iterator) park() {
+	it.file.Close()
+	it.file = nil
+	it.reader = nil
+	it.readers.open.Remove(it.elem)
+	it.elem = nil
+	it.segments = append([]segment{it.current}, it.segments...)
+	it.offset = it.pos
+}
+
+func (it *iterator) closeFile() {
+	if it.file == nil {
+		return
+	}
+	it.file.Close()
+	it.file = nil
+	it.reader = nil
+	it.release()
+}
+
+// release возвращает слот итератора
+func (it *iterator) release() {
+	it.readers.open.Remove(it.elem)
+	it.elem = nil
+	<-it.readers.slots
+}
+
+// recordHeap упорядочивает очередные записи итераторов по номеру
+type recordHeap []heapItem
+
+type heapItem struct {
+	rec *Record
+	it  *iterator
+}
+
+func (h recordHeap) Len() int           { return len(h) }
+func (h recordHeap) Less(i, j int) bool { return h[i].rec.Seq < h[j].rec.Seq }
+func (h recordHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *recordHeap) Push(x any)        { *h = append(*h, x.(heapItem)) }
+func (h *recordHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// merge сливает записи нескольких тем в порядке номеров
+func merge(iters []*iterator, fn func(*Record) bool) error {
+	defer func() {
+		for _, it := range iters {
+			it.closeFile()
+		}
+	}()
+
+	h := make(recordHeap, 0, len(iters))
+	for _, it := range iters {
+		rec, err := it.next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h = append(h, heapItem{rec: rec, it: it})
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		item := h[0]
+		if !fn(item.rec) {
+			return nil
+		}
+
+		rec, err := item.it.next()
+		switch {
+		case errors.Is(err, io.EOF):
+			heap.Pop(&h)
+		case err != nil:
+			return err
+		default:
+			h[0].rec = rec
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
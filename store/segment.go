@@ -0,0 +1,356 @@
+package store
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+	// subjectFile - файл с темой журнала в каталоге темы
+	subjectFile = "subject"
+	// indexIntervalBytes - шаг разреженного индекса номеров внутри сегмента
+	indexIntervalBytes = 4096
+)
+
+// indexEntry связывает номер записи со смещением в файле сегмента
+type indexEntry struct {
+	seq    uint64
+	offset int64
+}
+
+// segment - файл журнала темы
+type segment struct {
+	path     string
+	firstSeq uint64
+	// maxTime - самое позднее время записи в сегменте. Время записей задает
+	// вызывающий, и оно не обязано расти вместе с номерами.
+	maxTime time.Time
+	index   []indexEntry
+}
+
+// seek возвращает смещение, с которого нужно читать сегмент,
+// чтобы не пропустить запись с номером from
+func (seg *segment) seek(from uint64) int64 {
+	i := sort.Search(len(seg.index), func(i int) bool {
+		return seg.index[i].seq > from
+	})
+	if i == 0 {
+		return 0
+	}
+	return seg.index[i-1].offset
+}
+
+// addIndex добавляет запись в индекс, если с прошлой отметки
+// набралось достаточно данных
+func (seg *segment) addIndex(seq uint64, offset int64) {
+	if n := len(seg.index); n > 0 && offset-seg.index[n-1].offset < indexIntervalBytes {
+		return
+	}
+	seg.index = append(seg.index, indexEntry{seq: seq, offset: offset})
+}
+
+// subjectLog - журнал одной темы. Доступ к нему синхронизирует Store.mu.
+type subjectLog struct {
+	subject  string
+	dir      string
+	segments []segment
+
+	// active - последний сегмент, открытый на запись. Открыт, только пока
+	// журнал в списке недавних Store.open.
+	active     *os.File
+	elem       *list.Element
+	activeSize int64
+	lastSeq    uint64
+	dirty      bool // есть записи, не сброшенные на диск
+}
+
+func createSubjectLog(subject, dir string, policy SyncPolicy) (*subjectLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: create subject dir: %w", err)
+	}
+	if err := writeSubject(dir, subject, policy); err != nil {
+		return nil, err
+	}
+	if policy == SyncAlways {
+		if err := syncDir(filepath.Dir(dir)); err != nil {
+			return nil, err
+		}
+	}
+	return &subjectLog{subject: subject, dir: dir}, nil
+}
+
+// writeSubject сохраняет тему в каталоге журнала до первого сегмента
+func writeSubject(dir, subject string, policy SyncPolicy) error {
+	f, err := os.Create(filepath.Join(dir, subjectFile))
+	if err != nil {
+		return fmt.Errorf("store: create subject file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(subject); err != nil {
+		return fmt.Errorf("store: write subject file: %w", err)
+	}
+	if policy == SyncNever {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("store: sync subject file: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// openSubjectLog восстанавливает журнал темы: проверяет записи всех сегментов,
+// отрезает оборванный или поврежденный хвост и строит индекс. Последний
+// сегмент откроется на запись при первом append. Возвращает число
+// отрезанных байт.
+func openSubjectLog(subject, dir string) (*subjectLog, int64, error) {
+	log := &subjectLog{subject: subject, dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("store: read subject dir: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		log.segments = append(log.segments, segment{
+			path:     filepath.Join(dir, name),
+			firstSeq: firstSeq,
+		})
+	}
+	sort.Slice(log.segments, func(i, j int) bool {
+		return log.segments[i].firstSeq < log.segments[j].firstSeq
+	})
+
+	var truncated int64
+	for i := range log.segments {
+		size, cut, err := log.recoverSegment(&log.segments[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		truncated += cut
+		log.activeSize = size
+	}
+
+	if n := len(log.segments); n > 0 {
+		if last := log.segments[n-1]; log.lastSeq == 0 && last.firstSeq > 0 {
+			log.lastSeq = last.firstSeq - 1
+		}
+	}
+
+	return log, truncated, nil
+}
+
+// recoverSegment читает сегмент целиком и обрезает его по последней целой
+// записи. Возвращает размер сегмента после восстановления и число отрезанных байт.
+func (l *subjectLog) recoverSegment(seg *segment) (int64, int64, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: open segment: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: stat segment: %w", err)
+	}
+
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Оборванная при сбое или поврежденная запись: все, что дальше,
+			// не было подтверждено целиком
+			break
+		}
+		if rec.Seq <= l.lastSeq {
+			// Номера должны расти, иначе это остатки старых данных
+			break
+		}
+		if rec.Time.After(seg.maxTime) {
+			seg.maxTime = rec.Time
+		}
+		seg.addIndex(rec.Seq, offset)
+		l.lastSeq = rec.Seq
+		offset += int64(n)
+	}
+
+	cut := info.Size() - offset
+	if cut > 0 {
+		if err := os.Truncate(seg.path, offset); err != nil {
+			return 0, 0, fmt.Errorf("store: truncate segment: %w", err)
+		}
+	}
+	return offset, cut, nil
+}
+
+// append дописывает запись, начиная новый сегмент при превышении размера
+func (l *subjectLog) append(rec *Record, opts Options) error {
+	if l.active == nil && len(l.segments) > 0 && l.activeSize < opts.MaxSegmentBytes {
+		if err := l.reopen(); err != nil {
+			return err
+		}
+	}
+	if l.active == nil || l.activeSize >= opts.MaxSegmentBytes {
+		if err := l.roll(rec, opts.Sync); err != nil {
+			return err
+		}
+	}
+
+	buf := encodeRecord(rec)
+	n, err := l.active.Write(buf)
+	if err != nil {
+		// Отрезаем частично записанную запись, чтобы следующая легла за целой
+		if n > 0 {
+			l.active.Truncate(l.activeSize)
+		}
+		return fmt.Errorf("store: write record: %w", err)
+	}
+	l.dirty = true
+
+	if opts.Sync == SyncAlways {
+		if err := l.sync(); err != nil {
+			// Неподтвержденная запись не должна остаться в журнале
+			l.active.Truncate(l.activeSize)
+			return err
+		}
+	}
+
+	seg := &l.segments[len(l.segments)-1]
+	if rec.Time.After(seg.maxTime) {
+		seg.maxTime = rec.Time
+	}
+	seg.addIndex(rec.Seq, l.activeSize)
+	l.activeSize += int64(n)
+	l.lastSeq = rec.Seq
+	return nil
+}
+
+// roll закрывает текущий сегмент и открывает новый, начинающийся с rec
+func (l *subjectLog) roll(rec *Record, policy SyncPolicy) error {
+	if l.active != nil {
+		if policy != SyncNever {
+			if err := l.sync(); err != nil {
+				return err
+			}
+		}
+		if err := l.active.Close(); err != nil {
+			return fmt.Errorf("store: close segment: %w", err)
+		}
+		l.active = nil
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", rec.Seq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: create segment: %w", err)
+	}
+	if policy == SyncAlways {
+		if err := syncDir(l.dir); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	l.active = f
+	l.activeSize = 0
+	l.segments = append(l.segments, segment{
+		path:     path,
+		firstSeq: rec.Seq,
+	})
+	return nil
+}
+
+// reopen снова открывает на запись последний сегмент
+func (l *subjectLog) reopen() error {
+	f, err := os.OpenFile(l.segments[len(l.segments)-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: open segment: %w", err)
+	}
+	l.active = f
+	return nil
+}
+
+// sync сбрасывает записанные данные активного сегмента на диск
+func (l *subjectLog) sync() error {
+	if !l.dirty || l.active == nil {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return fmt.Errorf("store: sync segment: %w", err)
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *subjectLog) close() error {
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+// seekTime возвращает номер первой записи первого сегмента, в котором
+// есть записи не раньше t, или 0, если таких сегментов нет
+func (l *subjectLog) seekTime(t time.Time) uint64 {
+	for _, seg := range l.segments {
+		if !seg.maxTime.Before(t) {
+			return seg.firstSeq
+		}
+	}
+	return 0
+}
+
+// iterator возвращает итератор по записям с номерами не меньше from
+func (l *subjectLog) iterator(from uint64) *iterator {
+	// Первый сегмент, который может содержать запись from
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].firstSeq > from
+	})
+	if i > 0 {
+		i--
+	}
+
+	it := &iterator{subject: l.subject, from: from}
+	if i < len(l.segments) {
+		it.offset = l.segments[i].seek(from)
+		it.segments = make([]segment, len(l.segments)-i)
+		copy(it.segments, l.segments[i:])
+	}
+	return it
+}
+
+// syncDir сбрасывает на диск содержимое каталога, чтобы новые файлы
+// пережили сбой питания
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("store: open dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("store: sync dir: %w", err)
+	}
+	return nil
+}
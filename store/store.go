@@ -0,0 +1,403 @@
+// Package store реализует append-only журнал сообщений на диске.
+//
+// У каждой темы свой каталог с сегментами, имя сегмента - номер первой
+// записи в нем. Каталог назван хешем темы, а сама тема лежит в нем
+// в файле subject. Номера записей сквозные для всего журнала и строго растут,
+// поэтому записи разных тем можно читать в общем порядке публикации.
+//
+// Журнал работает как write-ahead log: запись считается подтвержденной,
+// когда Append вернул nil, а при политике SyncAlways - уже сброшенной на диск.
+// Каждая запись защищена контрольной суммой; при открытии журнал отрезает
+// оборванные при сбое записи и заново строит индексы тем.
+package store
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxSegmentBytes - размер, после которого открывается новый сегмент
+	defaultMaxSegmentBytes = 64 << 20
+	// defaultSyncInterval - период сброса на диск для политики SyncInterval
+	defaultSyncInterval = time.Second
+	// defaultMaxOpenFiles - сколько сегментов держать открытыми на запись
+	defaultMaxOpenFiles = 256
+)
+
+// ErrOutOfOrder возвращается при попытке дописать запись с номером,
+// не превышающим номер последней записи журнала
+var ErrOutOfOrder = errors.New("store: sequence number is not increasing")
+
+// ErrRecordTooLarge возвращается для записи, которую журнал не сможет
+// прочитать при восстановлении
+var ErrRecordTooLarge = errors.New("store: record is too large")
+
+// Record - запись журнала
+type Record struct {
+	Subject string
+	Seq     uint64
+	Time    time.Time
+	Data    []byte
+}
+
+// SyncPolicy определяет, когда записи сбрасываются на диск
+type SyncPolicy int
+
+const (
+	// SyncAlways - fsync после каждой записи, подтвержденная запись не теряется
+	SyncAlways SyncPolicy = iota
+	// SyncInterval - fsync раз в SyncInterval, при сбое теряется последний интервал
+	SyncInterval
+	// SyncNever - сброс на диск остается операционной системе
+	SyncNever
+)
+
+// ParseSyncPolicy разбирает политику из строки: always, interval или never
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always", "":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return 0, fmt.Errorf("store: unknown sync policy %q", s)
+	}
+}
+
+// Options - параметры журнала
+type Options struct {
+	// MaxSegmentBytes - размер сегмента, после которого начинается новый
+	MaxSegmentBytes int64
+	// Sync - политика сброса записей на диск
+	Sync SyncPolicy
+	// SyncInterval - период сброса для политики SyncInterval
+	SyncInterval time.Duration
+	// MaxOpenFiles - сколько активных сегментов держать открытыми. Сегменты
+	// тем, в которые давно не писали, закрываются и открываются заново
+	// при следующей записи. Столько же сегментов на все одновременные
+	// Scan может быть открыто на чтение.
+	MaxOpenFiles int
+}
+
+// Store - журнал сообщений, разбитый по темам
+type Store struct {
+	dir  string
+	opts Options
+
+	mu        sync.RWMutex
+	logs      map[string]*subjectLog
+	open      *list.List    // журналы с открытым сегментом, недавние в начале
+	readSlots chan struct{} // слоты сегментов, открытых на чтение
+	lastSeq   uint64
+	closed    bool
+	truncated int64
+
+	stopSync     chan struct{}
+	syncDone     chan struct{}
+	stopSyncOnce sync.Once
+}
+
+// Open открывает журнал в каталоге dir, создавая его при необходимости
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.MaxSegmentBytes <= 0 {
+		opts.MaxSegmentBytes = defaultMaxSegmentBytes
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.MaxOpenFiles <= 0 {
+		opts.MaxOpenFiles = defaultMaxOpenFiles
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: create dir: %w", err)
+	}
+
+	s := &Store{
+		dir:  dir,
+		opts: opts,
+		logs: make(map[string]*subjectLog),
+		open: list.New(),
+
+		readSlots: make(chan struct{}, opts.MaxOpenFiles),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("store: read dir: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		subject, ok, err := readSubject(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			s.closeLogs()
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		log, truncated, err := openSubjectLog(subject, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			s.closeLogs()
+			return nil, err
+		}
+		s.truncated += truncated
+		s.logs[subject] = log
+		if log.lastSeq > s.lastSeq {
+			s.lastSeq = log.lastSeq
+		}
+	}
+
+	if opts.Sync == SyncInterval {
+		s.stopSync = make(chan struct{})
+		s.syncDone = make(chan struct{})
+		go s.syncLoop()
+	}
+
+	return s, nil
+}
+
+// TruncatedBytes возвращает число байт оборванных записей,
+// отрезанных при восстановлении журнала
+func (s *Store) TruncatedBytes() int64 {
+	return s.truncated
+}
+
+// LastSeq возвращает номер последней записи журнала
+func (s *Store) LastSeq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastSeq
+}
+
+// Subjects возвращает темы, для которых в журнале есть записи
+func (s *Store) Subjects() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subjects := make([]string, 0, len(s.logs))
+	for subject := range s.logs {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// Append дописывает запись в журнал темы
+func (s *Store) Append(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+	if rec.Seq <= s.lastSeq {
+		return ErrOutOfOrder
+	}
+	if recordMetaSize+len(rec.Data) > maxRecordSize {
+		return fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, len(rec.Data))
+	}
+
+	log, ok := s.logs[rec.Subject]
+	if !ok {
+		var err error
+		log, err = createSubjectLog(rec.Subject, filepath.Join(s.dir, subjectDir(rec.Subject)), s.opts.Sync)
+		if err != nil {
+			return err
+		}
+		s.logs[rec.Subject] = log
+	}
+
+	if log.active == nil && s.open.Len() >= s.opts.MaxOpenFiles {
+		if err := s.evict(s.open.Back().Value.(*subjectLog)); err != nil {
+			return err
+		}
+	}
+	err := log.append(rec, s.opts)
+	s.touch(log)
+	if err != nil {
+		return err
+	}
+	s.lastSeq = rec.Seq
+	return nil
+}
+
+// touch отмечает журнал как недавно использованный
+func (s *Store) touch(log *subjectLog) {
+	switch {
+	case log.active == nil:
+		if log.elem != nil {
+			s.open.Remove(log.elem)
+			log.elem = nil
+		}
+	case log.elem != nil:
+		s.open.MoveToFront(log.elem)
+	default:
+		log.elem = s.open.PushFront(log)
+	}
+}
+
+// evict закрывает активный сегмент журнала, в который дольше всех не писали.
+// Несброшенные записи перед этим уходят на диск, иначе SyncInterval
+// потерял бы их вместе с файлом.
+func (s *Store) evict(log *subjectLog) error {
+	if s.opts.Sync != SyncNever {
+		if err := log.sync(); err != nil {
+			return err
+		}
+	}
+	s.open.Remove(log.elem)
+	log.elem = nil
+	if err := log.close(); err != nil {
+		return fmt.Errorf("store: close segment: %w", err)
+	}
+	return nil
+}
+
+// Scan читает записи перечисленных тем с номерами не меньше from в порядке
+// номеров и передает их в fn, пока она возвращает true. Записи, дописанные
+// во время чтения, могут как попасть, так и не попасть в выборку.
+func (s *Store) Scan(subjects []string, from uint64, fn func(*Record) bool) error {
+	r := &readers{slots: s.readSlots, open: list.New()}
+	s.mu.RLock()
+	iters := make([]*iterator, 0, len(subjects))
+	for _, subject := range subjects {
+		if log, ok := s.logs[subject]; ok {
+			it := log.iterator(from)
+			it.readers = r
+			iters = append(iters, it)
+		}
+	}
+	s.mu.RUnlock()
+
+	return merge(iters, fn)
+}
+
+// SeekTime возвращает номер, начиная с которого Scan по темам subjects
+// не пропустит записи со временем не раньше t. Если таких записей нет,
+// возвращает номер, следующий за последним.
+func (s *Store) SeekTime(subjects []string, t time.Time) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	from := s.lastSeq + 1
+	for _, subject := range subjects {
+		if log, ok := s.logs[subject]; ok {
+			if seq := log.seekTime(t); seq > 0 && seq < from {
+				from = seq
+			}
+		}
+	}
+	return from
+}
+
+// Sync сбрасывает на диск все записи, дописанные после прошлого сброса
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLogs()
+}
+
+func (s *Store) syncLoop() {
+	defer close(s.syncDone)
+
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Sync()
+		case <-s.stopSync:
+			return
+		}
+	}
+}
+
+func (s *Store) syncLogs() error {
+	var errs []error
+	for _, log := range s.logs {
+		errs = append(errs, log.sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close сбрасывает записи на диск (кроме политики SyncNever) и закрывает файлы журнала
+func (s *Store) Close() error {
+	if s.stopSync != nil {
+		s.stopSyncOnce.Do(func() {
+			close(s.stopSync)
+			<-s.syncDone
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var errs []error
+	if s.opts.Sync != SyncNever {
+		errs = append(errs, s.syncLogs())
+	}
+	errs = append(errs, s.closeLogs())
+	return errors.Join(errs...)
+}
+
+func (s *Store) closeLogs() error {
+	var errs []error
+	for _, log := range s.logs {
+		errs = append(errs, log.close())
+	}
+	return errors.Join(errs...)
+}
+
+// subjectDir возвращает имя каталога темы. Длина имени не зависит от длины
+// темы, поэтому не упирается в ограничение файловой системы на имя файла.
+func subjectDir(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// readSubject возвращает тему, журнал которой лежит в каталоге dir.
+// ok равен false для посторонних каталогов и для каталога, созданного
+// до сбоя: файл темы пишется до первого сегмента, поэтому без него
+// в каталоге нет записей.
+func readSubject(dir string) (subject string, ok bool, err error) {
+	name := filepath.Base(dir)
+	if !isSubjectDir(name) {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, subjectFile))
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(data) == 0 {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("store: read subject: %w", err)
+	}
+	if subjectDir(string(data)) != name {
+		return "", false, fmt.Errorf("store: subject file does not match directory %s", dir)
+	}
+	return string(data), true, nil
+}
+
+// isSubjectDir сообщает, похоже ли имя на имя каталога темы
+func isSubjectDir(name string) bool {
+	_, err := hex.DecodeString(name)
+	return len(name) == sha256.Size*2 && err == nil
+}
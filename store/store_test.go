@@ -0,0 +1,459 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendRecords дописывает в журнал записи с номерами, идущими после последней
+func appendRecords(t *testing.T, s *Store, subject string, data ...string) {
+	t.Helper()
+	for _, d := range data {
+		require.NoError(t, s.Append(&Record{
+			Subject: subject,
+			Seq:     s.LastSeq() + 1,
+			Time:    time.Now(),
+			Data:    []byte(d),
+		}))
+	}
+}
+
+// scanAll возвращает данные всех записей тем, начиная с from
+func scanAll(t *testing.T, s *Store, subjects []string, from uint64) []string {
+	t.Helper()
+	var result []string
+	err := s.Scan(subjects, from, func(rec *Record) bool {
+		result = append(result, fmt.Sprintf("%s:%d:%s", rec.Subject, rec.Seq, rec.Data))
+		return true
+	})
+	require.NoError(t, err)
+	return result
+}
+
+// TestAppendScan проверяет запись и чтение журнала
+func TestAppendScan(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxSegmentBytes int64
+	}{
+		{
+			name: "Один сегмент",
+		},
+		{
+			name:            "Много сегментов",
+			maxSegmentBytes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(t.TempDir(), Options{MaxSegmentBytes: tt.maxSegmentBytes})
+			require.NoError(t, err)
+			defer s.Close()
+
+			appendRecords(t, s, "orders", "a", "b")
+			appendRecords(t, s, "payments", "c")
+			appendRecords(t, s, "orders", "d")
+
+			assert.Equal(t, uint64(4), s.LastSeq())
+			assert.Equal(t, []string{"orders", "payments"}, s.Subjects())
+
+			assert.Equal(t, []string{"orders:1:a", "orders:2:b", "orders:4:d"},
+				scanAll(t, s, []string{"orders"}, 1))
+			assert.Equal(t, []string{"orders:2:b", "payments:3:c", "orders:4:d"},
+				scanAll(t, s, []string{"orders", "payments"}, 2))
+			assert.Empty(t, scanAll(t, s, []string{"orders"}, 5))
+			assert.Empty(t, scanAll(t, s, []string{"unknown"}, 1))
+		})
+	}
+}
+
+// TestReopen проверяет, что журнал восстанавливается после перезапуска
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Options{MaxSegmentBytes: 32})
+	require.NoError(t, err)
+	appendRecords(t, s, "orders.eu", "a", "b", "c")
+	appendRecords(t, s, "заказы/2024", "d")
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, Options{MaxSegmentBytes: 32})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, uint64(4), s.LastSeq())
+	appendRecords(t, s, "orders.eu", "e")
+
+	assert.Equal(t, []string{
+		"orders.eu:1:a", "orders.eu:2:b", "orders.eu:3:c", "заказы/2024:4:d", "orders.eu:5:e",
+	}, scanAll(t, s, s.Subjects(), 1))
+}
+
+// TestSubjectDir проверяет, что длина темы не ограничена длиной имени
+// файла, а посторонние каталоги не принимаются за журналы тем
+func TestSubjectDir(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("a.", 127) + "zz"
+
+	s, err := Open(dir, Options{})
+	require.NoError(t, err)
+	appendRecords(t, s, long, "a")
+	appendRecords(t, s, "orders", "b")
+	require.NoError(t, s.Close())
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "backup"), 0o755))
+	// Каталог, созданный до сбоя без файла темы, пропускается
+	require.NoError(t, os.Mkdir(filepath.Join(dir, subjectDir("lost")), 0o755))
+
+	s, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.ElementsMatch(t, []string{long, "orders"}, s.Subjects())
+	appendRecords(t, s, "orders", "c")
+	assert.Equal(t, []string{long + ":1:a", "orders:2:b", "orders:3:c"},
+		scanAll(t, s, []string{long, "orders"}, 1))
+}
+
+// TestOpenSubjectError проверяет, что Open не теряет историю темы молча,
+// если файл темы нельзя прочитать
+func TestOpenSubjectError(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, subjectDir string)
+	}{
+		{
+			name: "Ошибка чтения",
+			corrupt: func(t *testing.T, subjectDir string) {
+				path := filepath.Join(subjectDir, subjectFile)
+				require.NoError(t, os.Remove(path))
+				require.NoError(t, os.Mkdir(path, 0o755))
+			},
+		},
+		{
+			name: "Чужая тема",
+			corrupt: func(t *testing.T, subjectDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(subjectDir, subjectFile), []byte("payments"), 0o644))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := Open(dir, Options{})
+			require.NoError(t, err)
+			appendRecords(t, s, "orders", "a")
+			require.NoError(t, s.Close())
+
+			tt.corrupt(t, filepath.Join(dir, subjectDir("orders")))
+			_, err = Open(dir, Options{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestMaxOpenFiles проверяет, что журнал держит открытыми не больше
+// MaxOpenFiles сегментов и дописывает в закрытые без новых сегментов
+func TestMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Sync: SyncInterval, SyncInterval: time.Hour, MaxOpenFiles: 2}
+
+	s, err := Open(dir, opts)
+	require.NoError(t, err)
+	subjects := []string{"a", "b", "c", "d", "e"}
+	var want []string
+	for round := range 3 {
+		for _, subject := range subjects {
+			appendRecords(t, s, subject, fmt.Sprint(round))
+			want = append(want, fmt.Sprintf("%s:%d:%d", subject, s.LastSeq(), round))
+			assert.LessOrEqual(t, s.open.Len(), 2)
+		}
+	}
+	for _, subject := range subjects {
+		assert.Len(t, segmentFiles(t, dir, subject), 1)
+	}
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, opts)
+	require.NoError(t, err)
+	defer s.Close()
+	// При запуске сегменты не открываются, пока в тему не пишут
+	assert.Zero(t, s.open.Len())
+	assert.Equal(t, want, scanAll(t, s, subjects, 1))
+}
+
+// TestSeekTime проверяет, что чтение по времени начинается с первого
+// сегмента, в котором есть подходящие записи
+func TestSeekTime(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{MaxSegmentBytes: 1})
+	require.NoError(t, err)
+	defer s.Close()
+
+	base := time.Now()
+	times := []time.Duration{0, time.Second, 3 * time.Second, 2 * time.Second, 4 * time.Second}
+	for i, offset := range times {
+		subject := "a"
+		if i%2 == 1 {
+			subject = "b"
+		}
+		require.NoError(t, s.Append(&Record{Subject: subject, Seq: uint64(i + 1), Time: base.Add(offset)}))
+	}
+
+	tests := []struct {
+		name     string
+		subjects []string
+		at       time.Duration
+		want     uint64
+	}{
+		{"Раньше всех записей", []string{"a", "b"}, -time.Second, 1},
+		{"Середина журнала", []string{"a", "b"}, 2 * time.Second, 3},
+		{"Время вне порядка номеров", []string{"b"}, 2 * time.Second, 4},
+		{"Позже всех записей", []string{"a", "b"}, 5 * time.Second, 6},
+		{"Неизвестная тема", []string{"c"}, 0, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.SeekTime(tt.subjects, base.Add(tt.at)))
+		})
+	}
+}
+
+// TestScanMaxOpenFiles проверяет, что чтение многих тем держит открытыми
+// не больше MaxOpenFiles сегментов на все одновременные Scan
+func TestScanMaxOpenFiles(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{MaxOpenFiles: 4, MaxSegmentBytes: 64})
+	require.NoError(t, err)
+	defer s.Close()
+
+	var subjects, want []string
+	for i := 0; i < 50; i++ {
+		subjects = append(subjects, fmt.Sprintf("orders.%d", i))
+	}
+	for round := range 3 {
+		for _, subject := range subjects {
+			appendRecords(t, s, subject, fmt.Sprint(round))
+			want = append(want, fmt.Sprintf("%s:%d:%d", subject, s.LastSeq(), round))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got []string
+			err := s.Scan(subjects, 1, func(rec *Record) bool {
+				assert.LessOrEqual(t, len(s.readSlots), 4)
+				got = append(got, fmt.Sprintf("%s:%d:%s", rec.Subject, rec.Seq, rec.Data))
+				return true
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, len(s.readSlots))
+}
+
+// TestAppendOutOfOrder проверяет, что номера записей только растут
+func TestAppendOutOfOrder(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Append(&Record{Subject: "a", Seq: 10, Time: time.Now()}))
+	assert.ErrorIs(t, s.Append(&Record{Subject: "b", Seq: 10, Time: time.Now()}), ErrOutOfOrder)
+	assert.ErrorIs(t, s.Append(&Record{Subject: "a", Seq: 3, Time: time.Now()}), ErrOutOfOrder)
+}
+
+// TestAppendTooLarge проверяет, что журнал не принимает запись, которую
+// отбросил бы при восстановлении
+func TestAppendTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	require.NoError(t, err)
+
+	largest := make([]byte, maxRecordSize-recordMetaSize)
+	require.NoError(t, s.Append(&Record{Subject: "a", Seq: 1, Time: time.Now(), Data: largest}))
+	err = s.Append(&Record{Subject: "a", Seq: 2, Time: time.Now(), Data: append(largest, 0)})
+	assert.ErrorIs(t, err, ErrRecordTooLarge)
+	assert.Equal(t, uint64(1), s.LastSeq())
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Zero(t, s.TruncatedBytes())
+	assert.Equal(t, uint64(1), s.LastSeq())
+}
+
+// TestScanStop проверяет остановку чтения по требованию обработчика
+func TestScanStop(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{MaxSegmentBytes: 1})
+	require.NoError(t, err)
+	defer s.Close()
+
+	appendRecords(t, s, "orders", "a", "b", "c")
+
+	var seen []uint64
+	require.NoError(t, s.Scan([]string{"orders"}, 1, func(rec *Record) bool {
+		seen = append(seen, rec.Seq)
+		return rec.Seq < 2
+	}))
+	assert.Equal(t, []uint64{1, 2}, seen)
+}
+
+// segmentFiles возвращает пути сегментов темы в порядке номеров
+func segmentFiles(t *testing.T, dir, subject string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, subjectDir(subject), "*"+segmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+// TestRecoverTornWrite имитирует сбой посреди записи: в конце сегмента
+// остается любая часть следующей записи
+func TestRecoverTornWrite(t *testing.T) {
+	torn := encodeRecord(&Record{Seq: 4, Time: time.Now(), Data: []byte("torn")})
+
+	for cut := 1; cut < len(torn); cut++ {
+		t.Run(fmt.Sprintf("Оборвано после %d байт", cut), func(t *testing.T) {
+			dir := t.TempDir()
+
+			s, err := Open(dir, Options{Sync: SyncAlways})
+			require.NoError(t, err)
+			appendRecords(t, s, "orders", "a", "b", "c")
+
+			// Процесс "падает", не закрыв журнал, и оставляет начало записи
+			files := segmentFiles(t, dir, "orders")
+			f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+			require.NoError(t, err)
+			_, err = f.Write(torn[:cut])
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			s, err = Open(dir, Options{Sync: SyncAlways})
+			require.NoError(t, err)
+			defer s.Close()
+
+			assert.Equal(t, int64(cut), s.TruncatedBytes())
+			assert.Equal(t, uint64(3), s.LastSeq())
+
+			// После восстановления журнал продолжает принимать записи
+			appendRecords(t, s, "orders", "d")
+			assert.Equal(t, []string{"orders:1:a", "orders:2:b", "orders:3:c", "orders:4:d"},
+				scanAll(t, s, []string{"orders"}, 1))
+		})
+	}
+}
+
+// TestRecoverCorruptRecord проверяет, что запись с неверной контрольной
+// суммой и все записи после нее отрезаются
+func TestRecoverCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Options{})
+	require.NoError(t, err)
+	appendRecords(t, s, "orders", "a", "b", "c")
+	appendRecords(t, s, "payments", "d")
+	require.NoError(t, s.Close())
+
+	// Портим данные второй записи
+	files := segmentFiles(t, dir, "orders")
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	recordSize := len(encodeRecord(&Record{Data: []byte("a")}))
+	data[2*recordSize-1] ^= 0xff
+	require.NoError(t, os.WriteFile(files[0], data, 0o644))
+
+	s, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, int64(2*recordSize), s.TruncatedBytes())
+	assert.Equal(t, uint64(4), s.LastSeq(), "Другие темы не пострадали")
+	assert.Equal(t, []string{"orders:1:a", "payments:4:d"},
+		scanAll(t, s, s.Subjects(), 1))
+}
+
+// TestSyncPolicy проверяет, что при любой политике закрытый журнал сохраняет записи
+func TestSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   SyncPolicy
+	}{
+		{name: "По умолчанию", policy: "", want: SyncAlways},
+		{name: "Каждая запись", policy: "always", want: SyncAlways},
+		{name: "По интервалу", policy: "interval", want: SyncInterval},
+		{name: "Без fsync", policy: "never", want: SyncNever},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseSyncPolicy(tt.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, policy)
+
+			dir := t.TempDir()
+			opts := Options{Sync: policy, SyncInterval: time.Millisecond, MaxSegmentBytes: 64}
+
+			s, err := Open(dir, opts)
+			require.NoError(t, err)
+			appendRecords(t, s, "orders", "a", "b", "c")
+			time.Sleep(5 * time.Millisecond)
+			require.NoError(t, s.Close())
+			require.NoError(t, s.Close(), "Повторное закрытие безопасно")
+
+			s, err = Open(dir, opts)
+			require.NoError(t, err)
+			defer s.Close()
+			assert.Equal(t, []string{"orders:1:a", "orders:2:b", "orders:3:c"},
+				scanAll(t, s, []string{"orders"}, 1))
+		})
+	}
+
+	_, err := ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+// TestScanIndex проверяет чтение с середины длинного сегмента по индексу
+func TestScanIndex(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{SyncInterval: time.Hour, Sync: SyncNever})
+	require.NoError(t, err)
+
+	payload := make([]byte, 1000)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Append(&Record{Subject: "big", Seq: uint64(i + 1), Time: time.Now(), Data: payload}))
+	}
+	require.NoError(t, s.Close())
+
+	// Индекс перестраивается при открытии
+	s, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	seg := s.logs["big"].segments[0]
+	assert.Greater(t, len(seg.index), 10)
+	assert.Greater(t, seg.seek(50), int64(0))
+
+	var first uint64
+	require.NoError(t, s.Scan([]string{"big"}, 50, func(rec *Record) bool {
+		first = rec.Seq
+		return false
+	}))
+	assert.Equal(t, uint64(50), first)
+}
@@ -0,0 +1,66 @@
+package subpub
+
+import "go.opentelemetry.io/otel/trace"
+
+// routedMessage - сообщение пачки, которому назначен номер и получатели
+type routedMessage struct {
+	m    *Message
+	d    *delivery
+	span trace.Span
+}
+
+// PublishBatch публикует сообщения по порядку за одну проверку состояния
+// брокера и, если включен журнал, один захват его блокировки. Ошибка одного
+// сообщения не мешает публикации остальных; i-я ошибка относится к msgs[i].
+func (ps *PubSub) PublishBatch(msgs []*Message) []error {
+	errs := make([]error, len(msgs))
+
+	ps.mu.RLock()
+	if ps.closed {
+		ps.mu.RUnlock()
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+		return errs
+	}
+	ps.publishing.Add(1)
+	ps.mu.RUnlock()
+
+	defer ps.publishing.Done()
+
+	routed := ps.routeBatch(msgs, errs)
+	// Очереди подписчиков могут быть заполнены, поэтому сообщения
+	// раскладываются уже после освобождения storeMu
+	for _, r := range routed {
+		ps.deliver(r.m, r.d, r.span)
+		r.d.release()
+	}
+	return errs
+}
+
+// routeBatch назначает номера и получателей всем корректным сообщениям пачки
+func (ps *PubSub) routeBatch(msgs []*Message, errs []error) []routedMessage {
+	if ps.store != nil {
+		ps.storeMu.Lock()
+		defer ps.storeMu.Unlock()
+	}
+
+	routed := make([]routedMessage, 0, len(msgs))
+	for i, msg := range msgs {
+		if errs[i] = ValidateSubject(msg.Subject); errs[i] != nil {
+			continue
+		}
+
+		m := newMessage(msg)
+		d := deliveries.Get().(*delivery)
+		span, err := ps.routeLocked(m, msg.Retain, d)
+		if err != nil {
+			endEnqueue(span, m, 0, err)
+			d.release()
+			errs[i] = err
+			continue
+		}
+		routed = append(routed, routedMessage{m: m, d: d, span: span})
+	}
+	return routed
+}
@@ -0,0 +1,86 @@
+package subpub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec переводит значения в байты и обратно, чтобы сообщения можно было
+// сохранить в журнал и передать клиентам через gRPC
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	// Unmarshal декодирует data в значение, на которое указывает v
+	Unmarshal(data []byte, v any) error
+	// ContentType возвращает MIME тип закодированных данных
+	ContentType() string
+}
+
+var (
+	// JSONCodec кодирует значения в JSON
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec кодирует значения в gob
+	GobCodec Codec = gobCodec{}
+	// ProtoCodec кодирует protobuf сообщения
+	ProtoCodec Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) ContentType() string                { return "application/json" }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("subpub: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal принимает указатель на сообщение (*T) или указатель на указатель
+// на сообщение (**T); во втором случае сообщение создается при необходимости
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("subpub: cannot unmarshal protobuf into %T", v)
+	}
+	elem := rv.Elem()
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	m, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("subpub: %s is not a proto.Message", elem.Type())
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
@@ -0,0 +1,163 @@
+package subpub
+
+import "sync"
+
+// dispatchBatch - сколько сообщений подписчика обрабатывает воркер подряд,
+// прежде чем уступить очередь другим подписчикам
+const dispatchBatch = 64
+
+// WithWorkers включает пул из n воркеров вместо отдельной горутины на каждую
+// подписку. Сообщения одного подписчика по-прежнему обрабатываются по одному
+// и в порядке публикации. Обработчик, который публикует с политикой
+// BlockPublisher, может занять все воркеры, поэтому пул должен быть больше
+// числа таких обработчиков. n <= 0 оставляет горутину на подписку.
+func WithWorkers(n int) Option {
+	return func(ps *PubSub) {
+		if n > 0 {
+			ps.workers = n
+		}
+	}
+}
+
+// dispatcher раздает воркерам подписки, в очередях которых есть работа.
+// Подписка стоит в очереди готовых не больше одного раза, поэтому ее
+// сообщения никогда не обрабатываются двумя воркерами одновременно.
+type dispatcher struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ready  []*subscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(workers int) *dispatcher {
+	d := &dispatcher{}
+	d.cond = sync.NewCond(&d.mu)
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *dispatcher) push(s *subscription) {
+	d.mu.Lock()
+	d.ready = append(d.ready, s)
+	d.mu.Unlock()
+	d.cond.Signal()
+}
+
+// pop ждет готовую подписку, false - пул остановлен
+func (d *dispatcher) pop() (*subscription, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.ready) == 0 && !d.closed {
+		d.cond.Wait()
+	}
+	if len(d.ready) == 0 {
+		return nil, false
+	}
+	s := d.ready[0]
+	d.ready[0] = nil
+	d.ready = d.ready[1:]
+	return s, true
+}
+
+func (d *dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		s, ok := d.pop()
+		if !ok {
+			return
+		}
+		s.dispatch()
+	}
+}
+
+// stop останавливает воркеров, когда все подписки завершены
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cond.Broadcast()
+	d.wg.Wait()
+}
+
+// wake ставит подписку в очередь пула, если у нее появилась работа.
+// Без пула сообщения разбирает горутина подписки.
+func (s *subscription) wake() {
+	if s.ps.dispatcher != nil && s.scheduled.CompareAndSwap(false, true) {
+		s.ps.dispatcher.push(s)
+	}
+}
+
+// dispatch обрабатывает часть очереди подписки на воркере пула
+func (s *subscription) dispatch() {
+	if s.step() {
+		// scheduled остается true: завершенная подписка больше не планируется
+		s.finish()
+		return
+	}
+	s.scheduled.Store(false)
+	// Сообщение могло прийти, пока флаг еще был поднят
+	if s.pending() {
+		s.wake()
+	}
+}
+
+// step обрабатывает до dispatchBatch сообщений и сообщает, завершена ли подписка
+func (s *subscription) step() bool {
+	s.handleRetained()
+	for i := 0; i < dispatchBatch; i++ {
+		// Отписка важнее оставшихся в очереди сообщений
+		select {
+		case <-s.stop:
+			s.discard()
+			return true
+		default:
+		}
+
+		select {
+		case msg := <-s.queue:
+			s.handle(msg)
+		default:
+			// Очередь пуста: при остановке брокера подписка дообработана
+			select {
+			case <-s.drain:
+				return true
+			default:
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// pending сообщает, есть ли у подписки работа для пула
+func (s *subscription) pending() bool {
+	if len(s.queue) > 0 {
+		return true
+	}
+	select {
+	case <-s.stop:
+		return true
+	case <-s.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish освобождает подписку, обработанную пулом
+func (s *subscription) finish() {
+	s.ps.metrics.SubscriptionEnded(s.subject)
+	close(s.done)
+	s.ps.wg.Done()
+}
+
+// replayAndDispatch читает историю в отдельной горутине, чтобы не занимать
+// воркер пула, и затем передает подписку пулу
+func (s *subscription) replayAndDispatch() {
+	s.replay()
+	s.ps.dispatcher.push(s)
+}
@@ -0,0 +1,31 @@
+package subpub
+
+import "errors"
+
+// Ошибки брокера. Проверять их следует через errors.Is: брокер может
+// дополнять их подробностями.
+var (
+	// ErrClosed возвращается при публикации или подписке после Close
+	ErrClosed = errors.New("subpub: broker is closed")
+	// ErrInvalidSubject возвращается, если тема или шаблон подписки
+	// записаны некорректно
+	ErrInvalidSubject = errors.New("subpub: invalid subject")
+	// ErrQuotaExceeded возвращается, если запрос превышает лимиты брокера
+	ErrQuotaExceeded = errors.New("subpub: quota exceeded")
+	// ErrSlowConsumer возвращается из Subscription.Err, если подписчик был
+	// отключен из-за переполнения очереди
+	ErrSlowConsumer = errors.New("subpub: slow consumer disconnected")
+	// ErrTooManyPanics возвращается из Subscription.Err, если подписчик был
+	// отключен после серии паник обработчика
+	ErrTooManyPanics = errors.New("subpub: handler keeps panicking")
+	// ErrNoStore возвращается при попытке прочитать историю у брокера без журнала
+	ErrNoStore = errors.New("subpub: message log is not configured")
+	// ErrNoResponders возвращается из Request, если на тему запроса никто
+	// не подписан
+	ErrNoResponders = errors.New("subpub: no responders")
+	// ErrNoReplyTo возвращается из Reply для сообщения, которое не является
+	// запросом или указывает в reply-to не временную тему ответов
+	ErrNoReplyTo = errors.New("subpub: message has no reply-to subject")
+	// ErrNotPersistable возвращается, если сообщение нельзя сохранить в журнал
+	ErrNotPersistable = errors.New("subpub: only string and []byte messages can be persisted")
+)
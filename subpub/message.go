@@ -0,0 +1,32 @@
+package subpub
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message - сообщение вместе с метаданными, которые назначает брокер
+type Message struct {
+	Subject string
+	// Seq - сквозной номер сообщения, строго растущий в порядке публикации
+	Seq uint64
+	// ID - уникальный идентификатор сообщения, назначается при публикации
+	ID   uuid.UUID
+	Time time.Time
+	// Headers - произвольные метаданные издателя
+	Headers map[string]string
+	// ContentType - MIME тип данных, если издатель его указал
+	ContentType string
+	Data        interface{}
+	// Retain при публикации сохраняет сообщение как последнее значение темы:
+	// новые подписчики получают его сразу после подписки. У доставленного
+	// сообщения флаг поднят, если оно пришло из сохраненных значений.
+	Retain bool
+
+	ctx context.Context // контекст обработки, задается брокером для каждого подписчика
+}
+
+// MsgHandler получает сообщение вместе с метаданными
+type MsgHandler func(msg *Message)
@@ -0,0 +1,80 @@
+package subpub
+
+import "time"
+
+// Metrics получает события брокера для сбора метрик. Методы вызываются
+// из горутин издателей и подписчиков и не должны блокироваться.
+type Metrics interface {
+	// MessagePublished - сообщение опубликовано в тему subject
+	MessagePublished(subject string)
+	// MessageDelivered - обработчик подписчика обработал сообщение темы subject за d
+	MessageDelivered(subject string, d time.Duration)
+	// MessageDropped - сообщение темы subject отброшено из-за переполнения очереди
+	MessageDropped(subject string)
+	// SubscriptionStarted и SubscriptionEnded отмечают начало и конец подписки на pattern
+	SubscriptionStarted(pattern string)
+	SubscriptionEnded(pattern string)
+}
+
+// WithMetrics подключает сбор метрик брокера
+func WithMetrics(m Metrics) Option {
+	return func(ps *PubSub) {
+		if m != nil {
+			ps.metrics = inboxMetrics{m}
+		}
+	}
+}
+
+// noopMetrics используется, пока метрики не подключены
+type noopMetrics struct{}
+
+func (noopMetrics) MessagePublished(string)                {}
+func (noopMetrics) MessageDelivered(string, time.Duration) {}
+func (noopMetrics) MessageDropped(string)                  {}
+func (noopMetrics) SubscriptionStarted(string)             {}
+func (noopMetrics) SubscriptionEnded(string)               {}
+
+// inboxMetrics учитывает все темы ответов на запросы под одной меткой:
+// каждый Request создает новую тему, и отдельные серии росли бы без предела
+type inboxMetrics struct {
+	Metrics
+}
+
+// inboxLabel - тема, под которой в метриках учитываются темы ответов
+const inboxLabel = "_INBOX"
+
+func metricSubject(subject string) string {
+	if isInbox(subject) {
+		return inboxLabel
+	}
+	return subject
+}
+
+func (m inboxMetrics) MessagePublished(subject string) {
+	m.Metrics.MessagePublished(metricSubject(subject))
+}
+
+func (m inboxMetrics) MessageDelivered(subject string, d time.Duration) {
+	m.Metrics.MessageDelivered(metricSubject(subject), d)
+}
+
+func (m inboxMetrics) MessageDropped(subject string) {
+	m.Metrics.MessageDropped(metricSubject(subject))
+}
+
+func (m inboxMetrics) SubscriptionStarted(pattern string) {
+	m.Metrics.SubscriptionStarted(metricSubject(pattern))
+}
+
+func (m inboxMetrics) SubscriptionEnded(pattern string) {
+	m.Metrics.SubscriptionEnded(metricSubject(pattern))
+}
+
+// QueueDepths возвращает число сообщений, ожидающих обработки, по шаблонам подписок
+func (ps *PubSub) QueueDepths() map[string]int {
+	depths := make(map[string]int)
+	ps.subscribers.walk(func(sub *subscription) {
+		depths[metricSubject(sub.subject)] += len(sub.queue)
+	})
+	return depths
+}
@@ -0,0 +1,106 @@
+package subpub
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// PanicError описывает панику обработчика подписчика
+type PanicError struct {
+	Subject string // шаблон подписки
+	Seq     uint64 // номер сообщения, на котором случилась паника
+	Value   interface{}
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("subpub: handler for %q panicked on message %d: %v", e.Subject, e.Seq, e.Value)
+}
+
+// WithErrorHandler задает обработчик ошибок подписчиков, в том числе
+// паник обработчиков сообщений (PanicError) и записей журнала, пропущенных
+// при воспроизведении истории (ReplayError). По умолчанию ошибки пишутся
+// в лог брокера.
+// Обработчик вызывается из горутины подписчика и не должен блокироваться.
+func WithErrorHandler(fn func(err error)) Option {
+	return func(ps *PubSub) {
+		if fn != nil {
+			ps.errorHandler = fn
+		}
+	}
+}
+
+// WithPanicLimit отключает подписчика с ошибкой ErrTooManyPanics, если его
+// обработчик паникует n раз подряд. 0 - не отключать.
+func WithPanicLimit(n int) Option {
+	return func(ps *PubSub) {
+		ps.panicLimit = n
+	}
+}
+
+// WithLogger задает логгер брокера. По умолчанию используется slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(ps *PubSub) {
+		if logger != nil {
+			ps.logger = logger
+		}
+	}
+}
+
+// logError - обработчик ошибок подписчиков по умолчанию
+func (ps *PubSub) logError(err error) {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		ps.logger.Error("handler panicked",
+			slog.String("subject", panicErr.Subject),
+			slog.Uint64("seq", panicErr.Seq),
+			slog.Any("panic", panicErr.Value),
+			slog.String("stack", string(panicErr.Stack)))
+		return
+	}
+	var replayErr *ReplayError
+	if errors.As(err, &replayErr) {
+		ps.logger.Error("skipping unreadable message log record",
+			slog.String("subject", replayErr.Subject),
+			slog.Uint64("seq", replayErr.Seq),
+			slog.Any("error", replayErr.Err))
+		return
+	}
+	ps.logger.Error("subscriber failed", slog.Any("error", err))
+}
+
+// handle вызывает обработчик, не давая его панике завершить процесс
+func (s *subscription) handle(msg *Message) {
+	start := time.Now()
+	msg, span := s.startHandle(msg)
+	defer func() {
+		v := recover()
+		if v != nil {
+			s.panicked(msg, v, debug.Stack())
+		}
+		endHandle(span, v)
+		s.ps.metrics.MessageDelivered(msg.Subject, time.Since(start))
+	}()
+	s.cb(msg)
+	s.panics = 0
+}
+
+func (s *subscription) panicked(msg *Message, v interface{}, stack []byte) {
+	s.ps.panics.Add(1)
+	s.panics++
+
+	err := &PanicError{
+		Subject: s.subject,
+		Seq:     msg.Seq,
+		Value:   v,
+		Stack:   stack,
+	}
+	s.ps.errorHandler(err)
+
+	if limit := s.ps.panicLimit; limit > 0 && s.panics >= limit {
+		s.disconnect(fmt.Errorf("%w: %w", ErrTooManyPanics, err))
+	}
+}
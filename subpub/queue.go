@@ -0,0 +1,96 @@
+package subpub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// GroupStrategy определяет, кому из участников очереди достанется сообщение
+type GroupStrategy int
+
+const (
+	// RoundRobin - участники получают сообщения по очереди
+	RoundRobin GroupStrategy = iota
+	// LeastLoaded - сообщение получает участник с самой короткой очередью
+	LeastLoaded
+)
+
+// WithGroupStrategy задает стратегию распределения сообщений в очередях
+func WithGroupStrategy(strategy GroupStrategy) Option {
+	return func(ps *PubSub) {
+		ps.groupStrategy = strategy
+	}
+}
+
+// delivery собирает получателей одного сообщения
+type delivery struct {
+	subs   []*subscription
+	groups map[string][]*subscription
+}
+
+// deliveries переиспользует delivery между публикациями, чтобы Publish
+// не выделял срез получателей на каждое сообщение
+var deliveries = sync.Pool{
+	New: func() interface{} { return new(delivery) },
+}
+
+// release очищает delivery и возвращает ее в пул
+func (d *delivery) release() {
+	clear(d.subs)
+	d.subs = d.subs[:0]
+	clear(d.groups)
+	deliveries.Put(d)
+}
+
+func (d *delivery) subscriber(sub *subscription) {
+	d.subs = append(d.subs, sub)
+}
+
+// group объединяет участников одноименной очереди из всех совпавших шаблонов,
+// чтобы сообщение досталось ровно одному из них
+func (d *delivery) group(name string, members []*subscription) {
+	if d.groups == nil {
+		d.groups = make(map[string][]*subscription)
+	}
+	if current, ok := d.groups[name]; ok {
+		// Срез принадлежит узлу дерева, поэтому дописываем в копию
+		members = append(current[:len(current):len(current)], members...)
+	}
+	d.groups[name] = members
+}
+
+// pick выбирает по одному участнику каждой очереди
+func (ps *PubSub) pick(d *delivery) {
+	for name, members := range d.groups {
+		d.subs = append(d.subs, ps.pickMember(name, members))
+	}
+}
+
+func (ps *PubSub) pickMember(group string, members []*subscription) *subscription {
+	cursor := ps.groupCursor(group).Add(1) - 1
+
+	start := int(cursor % uint64(len(members)))
+	if ps.groupStrategy == RoundRobin {
+		return members[start]
+	}
+
+	// При равной загрузке продолжаем обход с позиции курсора,
+	// чтобы простаивающие участники тоже получали сообщения по очереди
+	best := members[start]
+	for i := 1; i < len(members); i++ {
+		member := members[(start+i)%len(members)]
+		if len(member.queue) < len(best.queue) {
+			best = member
+		}
+	}
+	return best
+}
+
+// groupCursor возвращает счетчик сообщений очереди group
+func (ps *PubSub) groupCursor(group string) *atomic.Uint64 {
+	if cursor, ok := ps.groupCursors.Load(group); ok {
+		return cursor.(*atomic.Uint64)
+	}
+	cursor, _ := ps.groupCursors.LoadOrStore(group, new(atomic.Uint64))
+	return cursor.(*atomic.Uint64)
+}
@@ -0,0 +1,140 @@
+package subpub
+
+import (
+	"hash/maphash"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// registryShards - число шардов для подписок на темы без шаблонов
+const registryShards = 64
+
+// registry хранит подписки. Темы без шаблонов разложены по шардам со своими
+// блокировками, поэтому публикации в разные темы не ждут друг друга.
+// Шаблоны хранятся в дереве, которое меняется копированием при записи:
+// Publish читает текущий снимок дерева без блокировок.
+type registry struct {
+	seed   maphash.Seed
+	shards [registryShards]shard
+
+	mu        sync.Mutex // сериализует изменения дерева шаблонов
+	wildcards atomic.Pointer[trie]
+}
+
+type shard struct {
+	mu    sync.RWMutex
+	nodes map[string]*node
+	// Дополняет шард до строки кеша, чтобы соседние блокировки не мешали друг другу
+	_ [32]byte
+}
+
+func newRegistry() *registry {
+	r := &registry{seed: maphash.MakeSeed()}
+	for i := range r.shards {
+		r.shards[i].nodes = make(map[string]*node)
+	}
+	r.wildcards.Store(newTrie())
+	return r
+}
+
+// isPattern сообщает, есть ли в корректном шаблоне подписки токены-шаблоны
+func isPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, wildcardOne+wildcardTail)
+}
+
+func (r *registry) shard(subject string) *shard {
+	return &r.shards[maphash.String(r.seed, subject)%registryShards]
+}
+
+func (r *registry) insert(pattern string, sub *subscription) {
+	if isPattern(pattern) {
+		r.mu.Lock()
+		r.wildcards.Store(r.wildcards.Load().insert(pattern, sub))
+		r.mu.Unlock()
+		return
+	}
+
+	sh := r.shard(pattern)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	n, ok := sh.nodes[pattern]
+	if !ok {
+		n = newNode()
+		sh.nodes[pattern] = n
+	}
+	n.add(sub)
+}
+
+func (r *registry) remove(sub *subscription) {
+	if isPattern(sub.subject) {
+		r.mu.Lock()
+		r.wildcards.Store(r.wildcards.Load().remove(sub))
+		r.mu.Unlock()
+		return
+	}
+
+	sh := r.shard(sub.subject)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	n, ok := sh.nodes[sub.subject]
+	if !ok {
+		return
+	}
+	n.delete(sub)
+	if n.empty() {
+		delete(sh.nodes, sub.subject)
+	}
+}
+
+// match передает в m все подписки, шаблон которых совпадает с темой
+func (r *registry) match(subject string, m matcher) {
+	sh := r.shard(subject)
+	sh.mu.RLock()
+	if n, ok := sh.nodes[subject]; ok {
+		visitNode(n, m)
+	}
+	sh.mu.RUnlock()
+
+	r.wildcards.Load().match(subject, m)
+}
+
+// lookup возвращает узел, соответствующий шаблону темы
+func (r *registry) lookup(pattern string) (*node, bool) {
+	if isPattern(pattern) {
+		return r.wildcards.Load().lookup(pattern)
+	}
+
+	sh := r.shard(pattern)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	n, ok := sh.nodes[pattern]
+	return n, ok
+}
+
+// walk обходит все подписки. fn не должна менять реестр.
+func (r *registry) walk(fn func(*subscription)) {
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		for _, n := range sh.nodes {
+			walkNode(n, fn)
+		}
+		sh.mu.RUnlock()
+	}
+	r.wildcards.Load().walk(fn)
+}
+
+// empty сообщает, что в реестре не осталось подписок
+func (r *registry) empty() bool {
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		n := len(sh.nodes)
+		sh.mu.RUnlock()
+		if n > 0 {
+			return false
+		}
+	}
+	return r.wildcards.Load().root.empty()
+}
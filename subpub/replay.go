@@ -0,0 +1,280 @@
+package subpub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/imhasandl/vk-internship/store"
+)
+
+// WithStore включает сохранение сообщений в журнал. Пока журнал подключен,
+// публиковать можно только string и []byte.
+func WithStore(st *store.Store) Option {
+	return func(ps *PubSub) {
+		ps.store = st
+		ps.seq.Store(st.LastSeq())
+	}
+}
+
+type startKind int
+
+const (
+	startLatest startKind = iota
+	startEarliest
+	startSequence
+	startTime
+)
+
+// StartPosition - позиция журнала, с которой подписчик начинает получать сообщения
+type StartPosition struct {
+	kind startKind
+	seq  uint64
+	time time.Time
+}
+
+// StartLatest - только сообщения, опубликованные после подписки
+func StartLatest() StartPosition {
+	return StartPosition{kind: startLatest}
+}
+
+// StartEarliest - все сообщения журнала
+func StartEarliest() StartPosition {
+	return StartPosition{kind: startEarliest}
+}
+
+// StartAtSequence - сообщения с номерами не меньше seq
+func StartAtSequence(seq uint64) StartPosition {
+	return StartPosition{kind: startSequence, seq: seq}
+}
+
+// StartAtTime - сообщения, опубликованные не раньше t
+func StartAtTime(t time.Time) StartPosition {
+	return StartPosition{kind: startTime, time: t}
+}
+
+// WithStartPosition задает позицию журнала, с которой начинается подписка.
+// Сначала подписчик получает историю из журнала, затем новые сообщения.
+func WithStartPosition(pos StartPosition) SubscribeOption {
+	return func(s *subscription) {
+		s.start = pos
+	}
+}
+
+// replay передает подписчику сообщения из журнала, опубликованные до подписки
+func (s *subscription) replay() {
+	st := s.ps.store
+
+	var subjects []string
+	for _, subject := range st.Subjects() {
+		if matchSubject(s.subject, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+
+	from := uint64(1)
+	switch {
+	case s.start.kind == startSequence && s.start.seq > from:
+		from = s.start.seq
+	case s.start.kind == startTime:
+		// Сегменты, целиком записанные раньше t, не читаются
+		from = st.SeekTime(subjects, s.start.time)
+	}
+
+	err := st.Scan(subjects, from, func(rec *store.Record) bool {
+		if rec.Seq > s.replayTo {
+			return false
+		}
+		select {
+		case <-s.stop:
+			return false
+		default:
+		}
+
+		if s.start.kind == startTime && rec.Time.Before(s.start.time) {
+			return true
+		}
+		m, err := decodeMessage(rec)
+		if err != nil {
+			// Запись пропускается, но потеря истории не должна пройти незаметно
+			s.ps.errorHandler(&ReplayError{Subject: rec.Subject, Seq: rec.Seq, Err: err})
+			return true
+		}
+		s.handle(m)
+		return true
+	})
+	if err != nil {
+		s.disconnect(fmt.Errorf("subpub: replay: %w", err))
+	}
+}
+
+// ReplayError описывает запись журнала, которую не удалось прочитать
+// при воспроизведении истории подписчику
+type ReplayError struct {
+	Subject string // тема записи
+	Seq     uint64 // номер записи
+	Err     error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("subpub: cannot replay message %d of %q: %v", e.Seq, e.Subject, e.Err)
+}
+
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// persist сохраняет сообщение в журнал. Вызывается под ps.storeMu.
+func (ps *PubSub) persist(m *Message) error {
+	data, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	return ps.store.Append(&store.Record{
+		Subject: m.Subject,
+		Seq:     m.Seq,
+		Time:    m.Time,
+		Data:    data,
+	})
+}
+
+// Первый байт сохраненного сообщения хранит исходный тип данных. Записи
+// dataEnvelope начинаются с метаданных сообщения, за которыми следует
+// тип данных и сами данные.
+const (
+	dataBytes byte = iota
+	dataString
+	dataEnvelope
+)
+
+// encodeMessage сериализует сообщение в формат записи журнала:
+// [dataEnvelope][16 байт ID][content type][число заголовков][ключ, значение]...[тип][данные],
+// строки хранятся с длиной в формате uvarint
+func encodeMessage(m *Message) ([]byte, error) {
+	kind, data, err := encodeData(m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, 1+len(m.ID)+1+len(m.ContentType)+1+1+len(data))
+	b = append(b, dataEnvelope)
+	b = append(b, m.ID[:]...)
+	b = appendString(b, m.ContentType)
+	b = binary.AppendUvarint(b, uint64(len(m.Headers)))
+	for k, v := range m.Headers {
+		b = appendString(b, k)
+		b = appendString(b, v)
+	}
+	b = append(b, kind)
+	return append(b, data...), nil
+}
+
+func decodeMessage(rec *store.Record) (*Message, error) {
+	m := &Message{
+		Subject: rec.Subject,
+		Seq:     rec.Seq,
+		Time:    rec.Time,
+	}
+
+	b := rec.Data
+	if len(b) == 0 {
+		return nil, errors.New("subpub: empty record")
+	}
+	if b[0] == dataEnvelope {
+		r := recordReader{b: b[1:]}
+		copy(m.ID[:], r.bytes(len(m.ID)))
+		m.ContentType = r.string()
+		if n := r.uvarint(); n > 0 && r.err == nil {
+			m.Headers = make(map[string]string, min(n, uint64(len(r.b))))
+			for i := uint64(0); i < n && r.err == nil; i++ {
+				k := r.string()
+				m.Headers[k] = r.string()
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		b = r.b
+	}
+
+	data, err := decodeData(b)
+	if err != nil {
+		return nil, err
+	}
+	m.Data = data
+	return m, nil
+}
+
+func encodeData(data interface{}) (byte, []byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return dataBytes, v, nil
+	case string:
+		return dataString, []byte(v), nil
+	default:
+		return 0, nil, fmt.Errorf("%w: got %T", ErrNotPersistable, data)
+	}
+}
+
+func decodeData(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, errors.New("subpub: empty record")
+	}
+	switch b[0] {
+	case dataBytes:
+		return b[1:], nil
+	case dataString:
+		return string(b[1:]), nil
+	default:
+		return nil, fmt.Errorf("subpub: unknown record type %d", b[0])
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// recordReader читает поля записи журнала, запоминая первую ошибку
+type recordReader struct {
+	b   []byte
+	err error
+}
+
+var errShortRecord = errors.New("subpub: record is too short")
+
+func (r *recordReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errShortRecord
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errShortRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) string() string {
+	n := r.uvarint()
+	if n > uint64(len(r.b)) {
+		r.err = errShortRecord
+		return ""
+	}
+	return string(r.bytes(int(n)))
+}
@@ -0,0 +1,114 @@
+package subpub
+
+import (
+	"context"
+	"maps"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ReplyToHeader - заголовок запроса с темой, в которую ждут ответ
+const ReplyToHeader = "reply-to"
+
+// inboxPrefix - префикс временных тем для ответов на запросы
+const inboxPrefix = "_INBOX."
+
+// newInbox создает уникальную тему для ответов на один запрос
+func newInbox() string {
+	return inboxPrefix + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// isInbox сообщает, является ли тема временной темой ответов
+func isInbox(subject string) bool {
+	return strings.HasPrefix(subject, inboxPrefix)
+}
+
+// Request публикует запрос msg в тему subject и ждет первого ответа, пока
+// не отменен ctx. Если на тему никто не подписан, сразу возвращает
+// ErrNoResponders.
+func (ps *PubSub) Request(ctx context.Context, subject string, msg interface{}) (*Message, error) {
+	return ps.RequestMsg(ctx, &Message{Subject: subject, Data: msg})
+}
+
+// RequestMsg - Request для сообщения с заголовками и типом содержимого
+func (ps *PubSub) RequestMsg(ctx context.Context, msg *Message) (*Message, error) {
+	replies, err := ps.RequestMany(ctx, msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// RequestMany публикует запрос и собирает ответы, пока их не наберется n
+// или не будет отменен ctx (scatter-gather). n <= 0 - собирать до отмены ctx.
+// Ответы, полученные до отмены, возвращаются без ошибки; если ответов нет,
+// возвращается ошибка ctx.
+func (ps *PubSub) RequestMany(ctx context.Context, msg *Message, n int) ([]*Message, error) {
+	if err := ValidateSubject(msg.Subject); err != nil {
+		return nil, err
+	}
+
+	inbox := newInbox()
+	replies := make(chan *Message)
+	done := make(chan struct{})
+	sub, err := ps.SubscribeMsg(inbox, "", func(reply *Message) {
+		select {
+		case replies <- reply:
+		case <-done:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	// Обработчик не должен ждать, когда ответы больше не нужны
+	defer close(done)
+
+	req := *msg
+	req.Headers = maps.Clone(msg.Headers)
+	if req.Headers == nil {
+		req.Headers = make(map[string]string, 1)
+	}
+	req.Headers[ReplyToHeader] = inbox
+	req.Retain = false
+
+	receivers, err := ps.publish(&req)
+	if err != nil {
+		return nil, err
+	}
+	if receivers == 0 {
+		return nil, ErrNoResponders
+	}
+
+	var got []*Message
+	for n <= 0 || len(got) < n {
+		select {
+		case reply := <-replies:
+			got = append(got, reply)
+		case <-ctx.Done():
+			if len(got) == 0 {
+				return nil, ctx.Err()
+			}
+			return got, nil
+		case <-sub.Done():
+			// Брокер закрывается
+			if len(got) == 0 {
+				return nil, ErrClosed
+			}
+			return got, nil
+		}
+	}
+	return got, nil
+}
+
+// Reply отвечает на запрос req, полученный обработчиком SubscribeMsg.
+// Ответ уходит только во временную тему ответов: иначе издатель мог бы
+// заголовком reply-to заставить обработчик публиковать в любую тему.
+func (ps *PubSub) Reply(req *Message, data interface{}) error {
+	inbox := req.Headers[ReplyToHeader]
+	if !isInbox(inbox) {
+		return ErrNoReplyTo
+	}
+	return ps.PublishMsg(&Message{Subject: inbox, Data: data})
+}
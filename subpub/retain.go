@@ -0,0 +1,82 @@
+package subpub
+
+import (
+	"cmp"
+	"slices"
+)
+
+// retainedMessages возвращает сохраненные значения тем, совпадающих с шаблоном.
+// Вызывается под ps.retainedMu.
+func (ps *PubSub) retainedMessages(pattern string) []*Message {
+	if !isPattern(pattern) {
+		if msg, ok := ps.retained[pattern]; ok {
+			return []*Message{msg}
+		}
+		return nil
+	}
+
+	var msgs []*Message
+	for subject, msg := range ps.retained {
+		if matchSubject(pattern, subject) {
+			msgs = append(msgs, msg)
+		}
+	}
+	// Значения разных тем приходят в порядке публикации
+	slices.SortFunc(msgs, func(a, b *Message) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return msgs
+}
+
+// retain запоминает сообщение как последнее значение его темы.
+// Вызывается под ps.retainedMu.
+func (ps *PubSub) retain(m *Message) {
+	r := *m
+	r.Retain = true
+	ps.retained[m.Subject] = &r
+}
+
+// Retained возвращает последнее сохраненное значение темы
+func (ps *PubSub) Retained(subject string) (*Message, bool) {
+	ps.retainedMu.RLock()
+	defer ps.retainedMu.RUnlock()
+	msg, ok := ps.retained[subject]
+	return msg, ok
+}
+
+// ClearRetained удаляет сохраненные значения тем, совпадающих с шаблоном,
+// и возвращает число удаленных значений
+func (ps *PubSub) ClearRetained(pattern string) (int, error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return 0, err
+	}
+
+	ps.retainedMu.Lock()
+	defer ps.retainedMu.Unlock()
+
+	n := 0
+	for subject := range ps.retained {
+		if matchSubject(pattern, subject) {
+			delete(ps.retained, subject)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// handleRetained передает подписчику сохраненные значения, собранные при
+// подписке. Доступно только горутине или воркеру подписчика.
+func (s *subscription) handleRetained() {
+	for len(s.retained) > 0 {
+		select {
+		case <-s.stop:
+			s.retained = nil
+			return
+		default:
+		}
+		msg := s.retained[0]
+		s.retained = s.retained[1:]
+		s.handle(msg)
+	}
+	s.retained = nil
+}
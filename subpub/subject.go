@@ -0,0 +1,110 @@
+package subpub
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxSubjectLength - наибольшая длина темы в байтах
+	MaxSubjectLength = 256
+	// MaxSubjectTokens - наибольшее число токенов в теме
+	MaxSubjectTokens = 16
+	// systemPrefix начинает системные темы, например "$DLQ.orders"
+	systemPrefix = "$"
+)
+
+// SubjectError описывает, чем тема нарушает правила именования.
+// errors.Is(err, ErrInvalidSubject) для нее возвращает true.
+type SubjectError struct {
+	Subject string
+	Reason  string
+}
+
+func (e *SubjectError) Error() string {
+	return fmt.Sprintf("%v %q: %s", ErrInvalidSubject, e.Subject, e.Reason)
+}
+
+func (e *SubjectError) Is(target error) bool {
+	return target == ErrInvalidSubject
+}
+
+// ValidateSubject проверяет тему публикации. Тема состоит из непустых
+// токенов, разделенных точкой; токен содержит буквы, цифры, "-" и "_".
+// Системные темы начинаются с "$", шаблоны в теме не допускаются.
+func ValidateSubject(subject string) error {
+	return validate(subject, false)
+}
+
+// ValidatePattern проверяет шаблон подписки. Кроме правил для темы, токеном
+// может быть "*", а последним токеном - ">".
+func ValidatePattern(pattern string) error {
+	return validate(pattern, true)
+}
+
+// IsSystemSubject сообщает, относится ли тема к зарезервированным системным
+// темам. Клиенты сервера не могут публиковать в них.
+func IsSystemSubject(subject string) bool {
+	return strings.HasPrefix(subject, systemPrefix)
+}
+
+func validate(subject string, pattern bool) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &SubjectError{Subject: subject, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if subject == "" {
+		return invalid("subject is empty")
+	}
+	if len(subject) > MaxSubjectLength {
+		return invalid("subject is longer than %d bytes", MaxSubjectLength)
+	}
+	if !utf8.ValidString(subject) {
+		return invalid("subject is not valid UTF-8")
+	}
+
+	tokens := tokenize(subject)
+	if len(tokens) > MaxSubjectTokens {
+		return invalid("subject has more than %d tokens", MaxSubjectTokens)
+	}
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return invalid("token %d is empty", i+1)
+		case token == wildcardOne || token == wildcardTail:
+			if !pattern {
+				return invalid("wildcards are not allowed in a published subject")
+			}
+			if token == wildcardTail && i != len(tokens)-1 {
+				return invalid("%q must be the last token", wildcardTail)
+			}
+			continue
+		}
+
+		name := token
+		if i == 0 {
+			name = strings.TrimPrefix(token, systemPrefix)
+			if name == "" {
+				return invalid("system subject has empty name")
+			}
+		}
+		for _, r := range name {
+			if !validRune(r) {
+				return invalid("token %d contains invalid character %q", i+1, r)
+			}
+		}
+	}
+	return nil
+}
+
+// validRune проверяет символ токена
+func validRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_'
+}
+
+// MatchSubject проверяет, совпадает ли тема с шаблоном подписки
+func MatchSubject(pattern, subject string) bool {
+	return matchSubject(pattern, subject)
+}
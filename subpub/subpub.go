@@ -2,112 +2,525 @@ package subpub
 
 import (
 	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/imhasandl/vk-internship/store"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// defaultQueueSize - размер очереди подписчика по умолчанию
+const defaultQueueSize = 1024
+
 type MessageHandler func(msg interface{})
 
 type Subscription interface {
 	Unsubscribe()
+	// Done закрывается, когда подписка перестает получать сообщения
+	Done() <-chan struct{}
+	// Err возвращает причину завершения подписки, если она была отключена брокером
+	Err() error
+	// Dropped возвращает число сообщений, отброшенных из-за переполнения очереди
+	Dropped() uint64
 }
 
 type SubPub interface {
-	Subscribe(subject string, cb MessageHandler) (Subscription, error)
+	Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error)
+	// SubscribeQueue подписывает участника очереди group: каждое сообщение
+	// получает только один из участников очереди
+	SubscribeQueue(subject, group string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error)
+	// SubscribeMsg подписывает обработчик, которому нужны метаданные сообщения.
+	// Пустая group означает обычную подписку.
+	SubscribeMsg(subject, group string, cb MsgHandler, opts ...SubscribeOption) (Subscription, error)
 	Publish(subject string, msg interface{}) error
+	// PublishMsg публикует сообщение с заголовками и типом содержимого
+	PublishMsg(msg *Message) error
+	// PublishBatch публикует сообщения по порядку и возвращает ошибку
+	// публикации каждого из них
+	PublishBatch(msgs []*Message) []error
+	// Request публикует запрос и ждет первого ответа
+	Request(ctx context.Context, subject string, msg interface{}) (*Message, error)
+	// RequestMany публикует запрос и собирает до n ответов
+	RequestMany(ctx context.Context, msg *Message, n int) ([]*Message, error)
 	Close(ctx context.Context) error
 }
 
+// Option настраивает PubSub при создании
+type Option func(*PubSub)
+
+// WithQueueSize задает размер очереди каждого подписчика.
+// Пока очередь заполнена, Publish ждет освобождения места.
+func WithQueueSize(n int) Option {
+	return func(ps *PubSub) {
+		if n > 0 {
+			ps.queueSize = n
+		}
+	}
+}
+
+// OverflowPolicy определяет поведение при переполнении очереди подписчика
+type OverflowPolicy int
+
+const (
+	// BlockPublisher - Publish ждет, пока в очереди освободится место
+	BlockPublisher OverflowPolicy = iota
+	// DropNewest - новое сообщение отбрасывается
+	DropNewest
+	// DropOldest - из очереди вытесняется самое старое сообщение
+	DropOldest
+	// Disconnect - подписчик отключается с ошибкой ErrSlowConsumer
+	Disconnect
+)
+
+// SubscribeOption настраивает отдельную подписку
+type SubscribeOption func(*subscription)
+
+// WithOverflowPolicy задает политику для медленного подписчика
+func WithOverflowPolicy(p OverflowPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = p
+	}
+}
+
+// Stats - счетчики брокера
+type Stats struct {
+	Dropped      uint64 // сообщения, отброшенные из-за переполнения очередей
+	Disconnected uint64 // подписчики, отключенные брокером
+	Panics       uint64 // паники обработчиков
+}
+
+// subscription владеет своей очередью сообщений, которую разбирает
+// одна горутина, поэтому подписчик получает сообщения в порядке публикации.
 type subscription struct {
 	subject string
+	group   string
 	id      uuid.UUID
 	ps      *PubSub
+	cb      MsgHandler
+	queue   chan *Message
+	policy  OverflowPolicy
+	dropped atomic.Uint64
+	err     atomic.Pointer[error]
+	panics  int // паники обработчика подряд, доступно только горутине подписчика
+	// scheduled поднят, пока подписка стоит в очереди пула или ее разбирает воркер
+	scheduled atomic.Bool
+
+	start    StartPosition
+	replayTo uint64 // последний номер, который подписчик получает из журнала
+	// retained - сохраненные значения тем, которые подписчик получает первыми
+	retained []*Message
+
+	stop      chan struct{} // закрывается при отписке
+	drain     chan struct{} // закрывается при Close: обработать остаток очереди и выйти
+	done      chan struct{} // закрывается после остановки горутины подписчика
+	stopOnce  sync.Once
+	drainOnce sync.Once
+}
+
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *subscription) Err() error {
+	if err := s.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (s *subscription) Dropped() uint64 {
+	return s.dropped.Load()
 }
 
 func (s *subscription) Unsubscribe() {
-	s.ps.mu.Lock()
-	defer s.ps.mu.Unlock()
+	// Удаляем подписчика по UUID, опустевшие темы удаляются из реестра
+	s.ps.subscribers.remove(s)
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wake()
+}
 
-	subscribers, ok := s.ps.subscribers[s.subject]
-	if !ok {
+// enqueue кладет сообщение в очередь подписчика согласно его политике переполнения
+func (s *subscription) enqueue(msg *Message) {
+	switch s.policy {
+	case BlockPublisher:
+		select {
+		case s.queue <- msg:
+		case <-s.stop:
+		}
 		return
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- msg:
+				return
+			case <-s.stop:
+				return
+			default:
+			}
+			// Вытесняем самое старое сообщение; если обработчик успел
+			// забрать его сам, просто повторяем попытку.
+			select {
+			case old := <-s.queue:
+				s.drop(old)
+			default:
+			}
+		}
 	}
 
-	// Удаляем подписчика по UUID
-	delete(subscribers, s.id)
+	select {
+	case s.queue <- msg:
+	case <-s.stop:
+	default:
+		s.drop(msg)
+		if s.policy == Disconnect {
+			s.disconnect(ErrSlowConsumer)
+		}
+	}
+}
 
-	// Если подписчиков больше нет, удаляем тему
-	if len(subscribers) == 0 {
-		delete(s.ps.subscribers, s.subject)
+func (s *subscription) drop(msg *Message) {
+	s.dropped.Add(1)
+	s.ps.dropped.Add(1)
+	s.ps.metrics.MessageDropped(msg.Subject)
+}
+
+// disconnect отписывает подписчика по инициативе брокера
+func (s *subscription) disconnect(err error) {
+	if s.err.CompareAndSwap(nil, &err) {
+		s.ps.disconnected.Add(1)
+		s.Unsubscribe()
 	}
 }
 
-// PubSub - конкретная реализация SubPub интерфейса
-type PubSub struct {
-	subscribers map[string]map[uuid.UUID]MessageHandler
-	mu          sync.Mutex
-	wg          sync.WaitGroup
-	closed      bool
+// discard учитывает как отброшенные сообщения, оставшиеся в очереди
+// подписчика, которого отключил брокер
+func (s *subscription) discard() {
+	if s.Err() == nil {
+		return
+	}
+	for n := len(s.queue); n > 0; n-- {
+		select {
+		case msg := <-s.queue:
+			s.drop(msg)
+		default:
+			return
+		}
+	}
 }
 
-func NewSubPub() *PubSub {
-	return &PubSub{
-		subscribers: make(map[string]map[uuid.UUID]MessageHandler),
+// run последовательно вызывает обработчик для сообщений из очереди
+func (s *subscription) run() {
+	defer s.ps.wg.Done()
+	defer close(s.done)
+	defer s.ps.metrics.SubscriptionEnded(s.subject)
+
+	if s.start.kind != startLatest {
+		s.replay()
+	}
+	s.handleRetained()
+
+	for {
+		// Отписка важнее оставшихся в очереди сообщений
+		select {
+		case <-s.stop:
+			s.discard()
+			return
+		default:
+		}
+
+		select {
+		case msg := <-s.queue:
+			s.handle(msg)
+		case <-s.stop:
+			s.discard()
+			return
+		case <-s.drain:
+			for {
+				// Подписчик может быть отключен посреди разбора остатка
+				select {
+				case <-s.stop:
+					s.discard()
+					return
+				default:
+				}
+				select {
+				case msg := <-s.queue:
+					s.handle(msg)
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
-func (ps *PubSub) Subscribe(subject string, cb MessageHandler) (Subscription, error) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
+// PubSub - конкретная реализация SubPub интерфейса.
+//
+// Темы состоят из токенов, разделенных точкой: "orders.eu.created".
+// В подписке токен "*" совпадает с любым одним токеном, а последний
+// токен ">" - с одним и более токенами: "orders.*", "orders.>".
+type PubSub struct {
+	subscribers *registry
+	// mu защищает closed: Publish и Subscribe берут его на чтение,
+	// поэтому не мешают друг другу, а Close дожидается начатых вызовов
+	mu         sync.RWMutex
+	wg         sync.WaitGroup // горутины подписчиков
+	publishing sync.WaitGroup // незавершенные вызовы Publish
+	closed     bool
+	queueSize  int
 
-	if ps.closed {
-		return nil, context.Canceled
+	groupStrategy GroupStrategy
+	groupCursors  sync.Map // имя очереди -> *atomic.Uint64
+
+	workers    int
+	dispatcher *dispatcher // nil, если у каждой подписки своя горутина
+
+	seq atomic.Uint64 // номер последнего опубликованного сообщения
+	// storeMu упорядочивает запись в журнал и подписки с историей,
+	// без журнала публикации не сериализуются
+	storeMu sync.Mutex
+	store   *store.Store
+
+	// retainedMu упорядочивает сохранение значений тем и новые подписки
+	retainedMu sync.RWMutex
+	retained   map[string]*Message // последние сохраненные значения по темам
+
+	errorHandler func(err error)
+	panicLimit   int
+	metrics      Metrics
+	tracer       trace.Tracer // nil, если трассировка выключена
+	logger       *slog.Logger
+
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+	panics       atomic.Uint64
+}
+
+func NewSubPub(opts ...Option) *PubSub {
+	ps := &PubSub{
+		subscribers: newRegistry(),
+		queueSize:   defaultQueueSize,
+		retained:    make(map[string]*Message),
+		metrics:     noopMetrics{},
+		logger:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(ps)
 	}
+	if ps.errorHandler == nil {
+		ps.errorHandler = ps.logError
+	}
+	if ps.workers > 0 {
+		ps.dispatcher = newDispatcher(ps.workers)
+	}
+	return ps
+}
+
+func (ps *PubSub) Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	return ps.SubscribeMsg(subject, "", dataHandler(cb), opts...)
+}
+
+func (ps *PubSub) SubscribeQueue(subject, group string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	return ps.SubscribeMsg(subject, group, dataHandler(cb), opts...)
+}
 
-	if _, ok := ps.subscribers[subject]; !ok {
-		ps.subscribers[subject] = make(map[uuid.UUID]MessageHandler)
+// dataHandler передает обработчику только данные сообщения
+func dataHandler(cb MessageHandler) MsgHandler {
+	return func(msg *Message) {
+		cb(msg.Data)
 	}
+}
 
-	id := uuid.New()
+func (ps *PubSub) SubscribeMsg(subject, group string, cb MsgHandler, opts ...SubscribeOption) (Subscription, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
 
-	ps.subscribers[subject][id] = cb
+	if ps.closed {
+		return nil, ErrClosed
+	}
+	if err := ValidatePattern(subject); err != nil {
+		return nil, err
+	}
 
-	return &subscription{
+	sub := &subscription{
 		subject: subject,
-		id:      id,
+		group:   group,
+		id:      uuid.New(),
 		ps:      ps,
-	}, nil
+		cb:      cb,
+		queue:   make(chan *Message, ps.queueSize),
+		stop:    make(chan struct{}),
+		drain:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	if sub.start.kind == startLatest {
+		// Подписка попадает в реестр под retainedMu, поэтому каждое сохраненное
+		// значение она получит ровно один раз: либо сразу, либо из очереди
+		ps.retainedMu.Lock()
+		defer ps.retainedMu.Unlock()
+		// Участникам очереди сохраненные значения не достаются: иначе каждый
+		// новый участник получал бы их заново
+		if group == "" {
+			sub.retained = ps.retainedMessages(subject)
+		}
+	} else {
+		if ps.store == nil {
+			return nil, ErrNoStore
+		}
+		// Сообщения до текущего номера придут из журнала, остальные - в очередь.
+		// Публикации с журналом ждут, пока подписка не попадет в реестр.
+		ps.storeMu.Lock()
+		defer ps.storeMu.Unlock()
+		sub.replayTo = ps.seq.Load()
+	}
+
+	if ps.dispatcher != nil && (sub.start.kind != startLatest || len(sub.retained) > 0) {
+		// Пока читаются история и сохраненные значения, пул не берет подписку
+		sub.scheduled.Store(true)
+	}
+
+	ps.subscribers.insert(subject, sub)
+	ps.metrics.SubscriptionStarted(subject)
+
+	ps.wg.Add(1)
+	switch {
+	case ps.dispatcher == nil:
+		go sub.run()
+	case sub.start.kind != startLatest:
+		go sub.replayAndDispatch()
+	case len(sub.retained) > 0:
+		ps.dispatcher.push(sub)
+	}
+
+	return sub, nil
 }
 
 func (ps *PubSub) Publish(subject string, msg interface{}) error {
-	ps.mu.Lock()
+	return ps.PublishMsg(&Message{Subject: subject, Data: msg})
+}
 
+// PublishMsg публикует сообщение вместе с заголовками и типом содержимого.
+// Номер, время и идентификатор назначает брокер, msg при этом не меняется.
+func (ps *PubSub) PublishMsg(msg *Message) error {
+	_, err := ps.publish(msg)
+	return err
+}
+
+// publish публикует сообщение и возвращает число получателей
+func (ps *PubSub) publish(msg *Message) (int, error) {
+	ps.mu.RLock()
 	if ps.closed {
-		ps.mu.Unlock()
-		return context.Canceled
+		ps.mu.RUnlock()
+		return 0, ErrClosed
 	}
+	ps.publishing.Add(1)
+	ps.mu.RUnlock()
 
-	var handlers []MessageHandler
-	if subs, ok := ps.subscribers[subject]; ok {
-		handlers = make([]MessageHandler, 0, len(subs))
-		for _, handler := range subs {
-			handlers = append(handlers, handler)
-		}
+	defer ps.publishing.Done()
+
+	if err := ValidateSubject(msg.Subject); err != nil {
+		return 0, err
 	}
-	ps.mu.Unlock()
 
-	if len(handlers) > 0 {
-		ps.wg.Add(len(handlers)) // Увеличиваем счетчик для каждого обработчика
-		for _, handler := range handlers {
-			go func(h MessageHandler) {
-				defer ps.wg.Done()
-				h(msg) // Вызываем обработчик в отдельной горутине
-			}(handler)
+	m := newMessage(msg)
+	d := deliveries.Get().(*delivery)
+	defer d.release()
+
+	span, err := ps.route(m, msg.Retain, d)
+	if err != nil {
+		endEnqueue(span, m, 0, err)
+		return 0, err
+	}
+	ps.deliver(m, d, span)
+
+	return len(d.subs), nil
+}
+
+// newMessage копирует сообщение издателя и назначает ему идентификатор и время
+func newMessage(msg *Message) *Message {
+	return &Message{
+		Subject:     msg.Subject,
+		ID:          uuid.New(),
+		Time:        time.Now(),
+		Headers:     msg.Headers,
+		ContentType: msg.ContentType,
+		Data:        msg.Data,
+	}
+}
+
+// deliver кладет сообщение в очередь каждого получателя до возврата из
+// Publish, чтобы последовательные публикации сохраняли порядок
+func (ps *PubSub) deliver(m *Message, d *delivery, span trace.Span) {
+	for _, sub := range d.subs {
+		sub.enqueue(m)
+		sub.wake()
+	}
+	endEnqueue(span, m, len(d.subs), nil)
+}
+
+// route назначает сообщению номер, сохраняет его в журнал и собирает в d
+// получателей. С журналом номер и поиск подписчиков выполняются под storeMu,
+// чтобы подписка с историей получила каждое сообщение ровно один раз.
+func (ps *PubSub) route(m *Message, retain bool, d *delivery) (trace.Span, error) {
+	if ps.store != nil {
+		ps.storeMu.Lock()
+		defer ps.storeMu.Unlock()
+	}
+	return ps.routeLocked(m, retain, d)
+}
+
+// routeLocked - route, когда storeMu уже захвачен (если журнал включен)
+func (ps *PubSub) routeLocked(m *Message, retain bool, d *delivery) (trace.Span, error) {
+	if ps.store == nil {
+		m.Seq = ps.seq.Add(1)
+		span := ps.startEnqueue(m)
+		ps.metrics.MessagePublished(m.Subject)
+		ps.match(m, retain, d)
+		return span, nil
+	}
+
+	m.Seq = ps.seq.Load() + 1
+	span := ps.startEnqueue(m)
+	// Сообщение попадает в журнал до доставки подписчикам. Ответы на запросы
+	// нужны только ожидающему их отправителю, поэтому не сохраняются.
+	if !isInbox(m.Subject) {
+		if err := ps.persist(m); err != nil {
+			return span, err
 		}
 	}
+	ps.seq.Store(m.Seq)
+	ps.metrics.MessagePublished(m.Subject)
+	ps.match(m, retain, d)
+	return span, nil
+}
 
-	return nil
+// match собирает в d получателей сообщения. Значение темы сохраняется под
+// retainedMu вместе с поиском подписчиков, чтобы новая подписка не получила
+// его дважды.
+func (ps *PubSub) match(m *Message, retain bool, d *delivery) {
+	if retain {
+		ps.retainedMu.Lock()
+		defer ps.retainedMu.Unlock()
+		ps.retain(m)
+	}
+	ps.subscribers.match(m.Subject, d)
+	ps.pick(d)
+}
+
+// Stats возвращает текущие значения счетчиков брокера
+func (ps *PubSub) Stats() Stats {
+	return Stats{
+		Dropped:      ps.dropped.Load(),
+		Disconnected: ps.disconnected.Load(),
+		Panics:       ps.panics.Load(),
+	}
 }
 
 func (ps *PubSub) Close(ctx context.Context) error {
@@ -115,10 +528,25 @@ func (ps *PubSub) Close(ctx context.Context) error {
 	ps.closed = true
 	ps.mu.Unlock()
 
+	var subs []*subscription
+	ps.subscribers.walk(func(sub *subscription) {
+		subs = append(subs, sub)
+	})
+
 	done := make(chan struct{})
 
 	go func() {
+		// Сначала дожидаемся публикаций, начатых до закрытия,
+		// затем даем подписчикам обработать остаток очереди.
+		ps.publishing.Wait()
+		for _, sub := range subs {
+			sub.drainOnce.Do(func() { close(sub.drain) })
+			sub.wake()
+		}
 		ps.wg.Wait()
+		if ps.dispatcher != nil {
+			ps.dispatcher.stop()
+		}
 		close(done)
 	}()
 
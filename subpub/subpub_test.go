@@ -1,15 +1,41 @@
 package subpub
 
 import (
+    "bytes"
     "context"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "sync/atomic"
     "sync"
+    "strings"
     "testing"
     "time"
 
+    "github.com/google/uuid"
+    "github.com/imhasandl/vk-internship/store"
     "github.com/stretchr/testify/assert"
     "github.com/stretchr/testify/require"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// subscribersOf возвращает подписчиков, зарегистрированных на шаблон темы
+func subscribersOf(ps *PubSub, pattern string) (map[uuid.UUID]*subscription, bool) {
+    n, ok := ps.subscribers.lookup(pattern)
+    if !ok {
+        return nil, false
+    }
+    return n.subs, true
+}
+
 // TestSubscribe проверяет функционал подписки на сообщения
 func TestSubscribe(t *testing.T) {
     tests := []struct {
@@ -25,11 +51,16 @@ func TestSubscribe(t *testing.T) {
         {
             name:      "Подписка с пустым ключом",
             key:       "",
-            wantErr:   false,
+            wantErr:   true,
         },
         {
             name:      "Подписка на ключ со специальными символами",
             key:       "test-key!@#$%^&*()",
+            wantErr:   true,
+        },
+        {
+            name:      "Подписка на системную тему",
+            key:       "$DLQ.orders",
             wantErr:   false,
         },
         {
@@ -59,9 +90,7 @@ func TestSubscribe(t *testing.T) {
             
             // Проверяем что подписка существует в структурах данных
             if !tt.wantErr {
-                pubSub.mu.Lock()
-                subscribers, ok := pubSub.subscribers[tt.key]
-                pubSub.mu.Unlock()
+                subscribers, ok := subscribersOf(pubSub, tt.key)
                 
                 assert.True(t, ok, "Должна быть создана карта для ключа")
                 assert.Equal(t, 1, len(subscribers), "Должен быть один подписчик")
@@ -94,10 +123,10 @@ func TestPublish(t *testing.T) {
             expectMsg:    false,
         },
         {
-            name:         "Пустой ключ подписки и публикации",
-            subscribeKey: "",
-            publishKey:   "",
-            message:      "Сообщение для пустого ключа",
+            name:         "Системная тема",
+            subscribeKey: "$DLQ.orders",
+            publishKey:   "$DLQ.orders",
+            message:      "Сообщение для системной темы",
             expectMsg:    true,
         },
         {
@@ -168,8 +197,8 @@ func TestUnsubscribe(t *testing.T) {
             key:  "test-topic",
         },
         {
-            name: "Отписка с пустым ключом",
-            key:  "",
+            name: "Отписка от шаблона",
+            key:  "orders.*",
         },
     }
 
@@ -185,18 +214,14 @@ func TestUnsubscribe(t *testing.T) {
             require.NoError(t, err)
             
             // Проверяем что подписка существует
-            pubSub.mu.Lock()
-            _, ok := pubSub.subscribers[tt.key]
-            pubSub.mu.Unlock()
+            _, ok := subscribersOf(pubSub, tt.key)
             assert.True(t, ok, "Подписка должна быть создана")
             
             // Отписываемся
             subscription.Unsubscribe()
             
             // Проверяем что подписчик удален
-            pubSub.mu.Lock()
-            subscribers, ok := pubSub.subscribers[tt.key]
-            pubSub.mu.Unlock()
+            subscribers, ok := subscribersOf(pubSub, tt.key)
             if ok {
                 // Если ключ все еще существует, проверяем что подписчиков нет
                 assert.Equal(t, 0, len(subscribers), "Подписчики должны быть удалены")
@@ -292,4 +317,1828 @@ func TestClose(t *testing.T) {
             assert.Error(t, err, "Публикация в закрытый pubsub должна вернуть ошибку")
         })
     }
-}
\ No newline at end of file
+}
+// TestPublishOrder проверяет, что подписчик получает сообщения в порядке публикации
+func TestPublishOrder(t *testing.T) {
+    tests := []struct {
+        name        string
+        queueSize   int
+        subscribers int
+        messages    int
+        workers     int
+    }{
+        {
+            name:        "Один подписчик",
+            queueSize:   defaultQueueSize,
+            subscribers: 1,
+            messages:    1000,
+        },
+        {
+            name:        "Несколько подписчиков",
+            queueSize:   defaultQueueSize,
+            subscribers: 5,
+            messages:    1000,
+        },
+        {
+            name:        "Очередь меньше числа сообщений",
+            queueSize:   4,
+            subscribers: 3,
+            messages:    500,
+        },
+        {
+            name:        "Пул воркеров меньше числа подписчиков",
+            queueSize:   defaultQueueSize,
+            subscribers: 5,
+            messages:    1000,
+            workers:     2,
+        },
+        {
+            name:        "Пул воркеров и маленькая очередь",
+            queueSize:   4,
+            subscribers: 3,
+            messages:    500,
+            workers:     1,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub(WithQueueSize(tt.queueSize), WithWorkers(tt.workers))
+
+            received := make([][]int, tt.subscribers)
+            for i := 0; i < tt.subscribers; i++ {
+                i := i
+                _, err := pubSub.Subscribe("ordered", func(msg interface{}) {
+                    received[i] = append(received[i], msg.(int))
+                })
+                require.NoError(t, err)
+            }
+
+            for n := 0; n < tt.messages; n++ {
+                require.NoError(t, pubSub.Publish("ordered", n))
+            }
+
+            // Close дожидается обработки всех сообщений из очередей
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            for i := 0; i < tt.subscribers; i++ {
+                require.Len(t, received[i], tt.messages)
+                for n, msg := range received[i] {
+                    assert.Equal(t, n, msg, "Нарушен порядок доставки")
+                }
+            }
+        })
+    }
+}
+
+// TestOverflowPolicy проверяет поведение политик переполнения очереди
+func TestOverflowPolicy(t *testing.T) {
+    tests := []struct {
+        name             string
+        policy           OverflowPolicy
+        wantReceived     []int
+        wantDropped      uint64
+        wantDisconnected bool
+    }{
+        {
+            name:         "Отбросить новое сообщение",
+            policy:       DropNewest,
+            wantReceived: []int{0, 1, 2},
+            wantDropped:  3,
+        },
+        {
+            name:         "Вытеснить старое сообщение",
+            policy:       DropOldest,
+            wantReceived: []int{0, 4, 5},
+            wantDropped:  3,
+        },
+        {
+            name:             "Отключить подписчика",
+            policy:           Disconnect,
+            wantReceived:     []int{0},
+            wantDropped:      3,
+            wantDisconnected: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub(WithQueueSize(2))
+
+            // Обработчик зависает на первом сообщении, пока его не отпустят
+            started := make(chan struct{})
+            release := make(chan struct{})
+            var mu sync.Mutex
+            var received []int
+            handler := func(msg interface{}) {
+                mu.Lock()
+                received = append(received, msg.(int))
+                first := len(received) == 1
+                mu.Unlock()
+                if first {
+                    close(started)
+                    <-release
+                }
+            }
+
+            sub, err := pubSub.Subscribe("slow", handler, WithOverflowPolicy(tt.policy))
+            require.NoError(t, err)
+
+            require.NoError(t, pubSub.Publish("slow", 0))
+            <-started
+
+            // Очередь вмещает два сообщения, остальные переполняют ее
+            for n := 1; n <= 5; n++ {
+                require.NoError(t, pubSub.Publish("slow", n))
+            }
+            close(release)
+
+            if tt.wantDisconnected {
+                select {
+                case <-sub.Done():
+                case <-time.After(time.Second):
+                    t.Fatal("Таймаут: подписчик не был отключен")
+                }
+                assert.ErrorIs(t, sub.Err(), ErrSlowConsumer)
+                assert.Equal(t, uint64(1), pubSub.Stats().Disconnected)
+            } else {
+                assert.NoError(t, sub.Err())
+            }
+
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            mu.Lock()
+            defer mu.Unlock()
+            assert.Equal(t, tt.wantReceived, received)
+            assert.Equal(t, tt.wantDropped, sub.Dropped())
+            assert.Equal(t, tt.wantDropped, pubSub.Stats().Dropped)
+        })
+    }
+}
+
+// TestBlockPublisher проверяет, что политика по умолчанию не теряет сообщения
+func TestBlockPublisher(t *testing.T) {
+    pubSub := NewSubPub(WithQueueSize(1))
+
+    release := make(chan struct{})
+    var count int
+    sub, err := pubSub.Subscribe("slow", func(msg interface{}) {
+        <-release
+        count++
+    })
+    require.NoError(t, err)
+
+    published := make(chan struct{})
+    go func() {
+        for n := 0; n < 3; n++ {
+            assert.NoError(t, pubSub.Publish("slow", n))
+        }
+        close(published)
+    }()
+
+    select {
+    case <-published:
+        t.Fatal("Publish не должен завершиться, пока очередь заполнена")
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    close(release)
+    <-published
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, 3, count)
+    assert.Zero(t, sub.Dropped())
+}
+
+// TestWildcardSubscribe проверяет подписку на шаблоны тем
+func TestWildcardSubscribe(t *testing.T) {
+    tests := []struct {
+        name       string
+        pattern    string
+        publishKey string
+        expectMsg  bool
+    }{
+        {
+            name:       "Точное совпадение",
+            pattern:    "orders.eu.created",
+            publishKey: "orders.eu.created",
+            expectMsg:  true,
+        },
+        {
+            name:       "Один токен",
+            pattern:    "orders.*.created",
+            publishKey: "orders.eu.created",
+            expectMsg:  true,
+        },
+        {
+            name:       "Один токен не совпадает с несколькими",
+            pattern:    "orders.*",
+            publishKey: "orders.eu.created",
+            expectMsg:  false,
+        },
+        {
+            name:       "Хвост из нескольких токенов",
+            pattern:    "orders.>",
+            publishKey: "orders.eu.created",
+            expectMsg:  true,
+        },
+        {
+            name:       "Хвост требует хотя бы один токен",
+            pattern:    "orders.>",
+            publishKey: "orders",
+            expectMsg:  false,
+        },
+        {
+            name:       "Другой префикс",
+            pattern:    "orders.>",
+            publishKey: "payments.eu.created",
+            expectMsg:  false,
+        },
+        {
+            name:       "Все темы",
+            pattern:    ">",
+            publishKey: "payments",
+            expectMsg:  true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub()
+
+            msgChan := make(chan interface{}, 1)
+            _, err := pubSub.Subscribe(tt.pattern, func(msg interface{}) {
+                msgChan <- msg
+            })
+            require.NoError(t, err)
+
+            require.NoError(t, pubSub.Publish(tt.publishKey, "message"))
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            if tt.expectMsg {
+                require.Len(t, msgChan, 1, "Сообщение должно быть доставлено")
+                assert.Equal(t, "message", <-msgChan)
+            } else {
+                assert.Empty(t, msgChan, "Сообщение не должно быть доставлено")
+            }
+        })
+    }
+}
+
+// TestWildcardOverlap проверяет, что каждая подходящая подписка получает сообщение один раз
+func TestWildcardOverlap(t *testing.T) {
+    pubSub := NewSubPub()
+
+    var mu sync.Mutex
+    received := make(map[string]int)
+    for _, pattern := range []string{"a.b.c", "a.*.c", "a.>", "*.b.*", ">", "a.b"} {
+        pattern := pattern
+        _, err := pubSub.Subscribe(pattern, func(msg interface{}) {
+            mu.Lock()
+            received[pattern]++
+            mu.Unlock()
+        })
+        require.NoError(t, err)
+    }
+
+    require.NoError(t, pubSub.Publish("a.b.c", "message"))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, map[string]int{
+        "a.b.c": 1,
+        "a.*.c": 1,
+        "a.>":   1,
+        "*.b.*": 1,
+        ">":     1,
+    }, received)
+}
+
+// TestWildcardUnsubscribe проверяет очистку дерева подписок после отписки
+func TestWildcardUnsubscribe(t *testing.T) {
+    pubSub := NewSubPub()
+
+    first, err := pubSub.Subscribe("orders.*.created", func(msg interface{}) {})
+    require.NoError(t, err)
+    second, err := pubSub.Subscribe("orders.>", func(msg interface{}) {})
+    require.NoError(t, err)
+
+    first.Unsubscribe()
+    _, ok := subscribersOf(pubSub, "orders.*")
+    assert.False(t, ok, "Опустевшая ветка должна быть удалена")
+    _, ok = subscribersOf(pubSub, "orders.>")
+    assert.True(t, ok)
+
+    second.Unsubscribe()
+    assert.True(t, pubSub.subscribers.empty(), "Реестр должен стать пустым")
+}
+
+// TestSubscribeQueue проверяет распределение сообщений между участниками очереди
+func TestSubscribeQueue(t *testing.T) {
+    tests := []struct {
+        name     string
+        strategy GroupStrategy
+        members  int
+        messages int
+    }{
+        {
+            name:     "Round-robin",
+            strategy: RoundRobin,
+            members:  3,
+            messages: 300,
+        },
+        {
+            name:     "Наименее загруженный",
+            strategy: LeastLoaded,
+            members:  3,
+            messages: 300,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub(WithGroupStrategy(tt.strategy))
+
+            var mu sync.Mutex
+            perMember := make([]int, tt.members)
+            for i := 0; i < tt.members; i++ {
+                i := i
+                _, err := pubSub.SubscribeQueue("jobs", "workers", func(msg interface{}) {
+                    mu.Lock()
+                    perMember[i]++
+                    mu.Unlock()
+                })
+                require.NoError(t, err)
+            }
+
+            // Обычный подписчик получает все сообщения независимо от очереди
+            var all int
+            _, err := pubSub.Subscribe("jobs", func(msg interface{}) {
+                mu.Lock()
+                all++
+                mu.Unlock()
+            })
+            require.NoError(t, err)
+
+            for n := 0; n < tt.messages; n++ {
+                require.NoError(t, pubSub.Publish("jobs", n))
+            }
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            total := 0
+            for _, count := range perMember {
+                total += count
+                assert.NotZero(t, count, "Каждый участник очереди должен получать сообщения")
+            }
+            assert.Equal(t, tt.messages, total, "Каждое сообщение доставляется одному участнику")
+            assert.Equal(t, tt.messages, all)
+
+            if tt.strategy == RoundRobin {
+                for _, count := range perMember {
+                    assert.Equal(t, tt.messages/tt.members, count)
+                }
+            }
+        })
+    }
+}
+
+// TestLeastLoaded проверяет, что занятый участник очереди не получает новых сообщений
+func TestLeastLoaded(t *testing.T) {
+    pubSub := NewSubPub(WithGroupStrategy(LeastLoaded))
+
+    release := make(chan struct{})
+    busy := make(chan interface{}, 10)
+    _, err := pubSub.SubscribeQueue("jobs", "workers", func(msg interface{}) {
+        busy <- msg
+        <-release
+    })
+    require.NoError(t, err)
+
+    idle := make(chan interface{}, 10)
+    _, err = pubSub.SubscribeQueue("jobs", "workers", func(msg interface{}) {
+        idle <- msg
+    })
+    require.NoError(t, err)
+
+    // Первое сообщение достается первому участнику, и он зависает на нем,
+    // а его очередь заполняется, пока второй успевает все разбирать
+    require.NoError(t, pubSub.Publish("jobs", 0))
+    <-busy
+    require.NoError(t, pubSub.Publish("jobs", 1))
+    require.NoError(t, pubSub.Publish("jobs", 2))
+    for n := 3; n < 8; n++ {
+        // Ждем, пока свободный участник разберет свою очередь
+        require.Eventually(t, func() bool {
+            return len(idle) >= n-2
+        }, time.Second, time.Millisecond)
+        require.NoError(t, pubSub.Publish("jobs", n))
+    }
+
+    close(release)
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Len(t, busy, 1, "Занятый участник получил только часть сообщений")
+    assert.Equal(t, 8, len(busy)+len(idle)+1)
+}
+
+// TestSubscribeQueueWildcard проверяет очередь, участники которой подписаны на разные шаблоны
+func TestSubscribeQueueWildcard(t *testing.T) {
+    pubSub := NewSubPub()
+
+    var mu sync.Mutex
+    var received int
+    handler := func(msg interface{}) {
+        mu.Lock()
+        received++
+        mu.Unlock()
+    }
+
+    _, err := pubSub.SubscribeQueue("orders.*", "workers", handler)
+    require.NoError(t, err)
+    _, err = pubSub.SubscribeQueue("orders.>", "workers", handler)
+    require.NoError(t, err)
+    member, err := pubSub.SubscribeQueue("orders.eu", "workers", handler)
+    require.NoError(t, err)
+    member.Unsubscribe()
+
+    for n := 0; n < 10; n++ {
+        require.NoError(t, pubSub.Publish("orders.eu", n))
+    }
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, 10, received)
+}
+
+// newStorePubSub создает брокер с журналом во временном каталоге
+func newStorePubSub(t *testing.T, dir string) (*PubSub, *store.Store) {
+    t.Helper()
+    st, err := store.Open(dir, store.Options{})
+    require.NoError(t, err)
+    t.Cleanup(func() { st.Close() })
+    return NewSubPub(WithStore(st)), st
+}
+
+// collect подписывается и собирает данные и номера полученных сообщений
+func collect(t *testing.T, pubSub *PubSub, pattern string, opts ...SubscribeOption) func() []string {
+    t.Helper()
+    var mu sync.Mutex
+    var received []string
+    _, err := pubSub.SubscribeMsg(pattern, "", func(msg *Message) {
+        mu.Lock()
+        received = append(received, fmt.Sprintf("%d:%v", msg.Seq, msg.Data))
+        mu.Unlock()
+    }, opts...)
+    require.NoError(t, err)
+    return func() []string {
+        mu.Lock()
+        defer mu.Unlock()
+        return append([]string(nil), received...)
+    }
+}
+
+// TestReplay проверяет чтение истории из журнала перед новыми сообщениями
+func TestReplay(t *testing.T) {
+    tests := []struct {
+        name    string
+        pattern string
+        start   func(middle time.Time) StartPosition
+        want    []string
+    }{
+        {
+            name:    "Только новые сообщения",
+            pattern: "orders.eu",
+            start:   func(time.Time) StartPosition { return StartLatest() },
+            want:    []string{"5:new"},
+        },
+        {
+            name:    "С начала журнала",
+            pattern: "orders.eu",
+            start:   func(time.Time) StartPosition { return StartEarliest() },
+            want:    []string{"1:a", "3:c", "5:new"},
+        },
+        {
+            name:    "С номера",
+            pattern: "orders.eu",
+            start:   func(time.Time) StartPosition { return StartAtSequence(2) },
+            want:    []string{"3:c", "5:new"},
+        },
+        {
+            name:    "С момента времени",
+            pattern: "orders.>",
+            start:   func(middle time.Time) StartPosition { return StartAtTime(middle) },
+            want:    []string{"3:c", "4:d", "5:new"},
+        },
+        {
+            name:    "Шаблон темы",
+            pattern: "orders.*",
+            start:   func(time.Time) StartPosition { return StartEarliest() },
+            want:    []string{"1:a", "2:b", "3:c", "4:d", "5:new"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub, _ := newStorePubSub(t, t.TempDir())
+
+            require.NoError(t, pubSub.Publish("orders.eu", "a"))
+            require.NoError(t, pubSub.Publish("orders.us", "b"))
+            time.Sleep(10 * time.Millisecond)
+            middle := time.Now()
+            require.NoError(t, pubSub.Publish("orders.eu", "c"))
+            require.NoError(t, pubSub.Publish("orders.us", "d"))
+
+            received := collect(t, pubSub, tt.pattern, WithStartPosition(tt.start(middle)))
+
+            require.NoError(t, pubSub.Publish("orders.eu", "new"))
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            assert.Equal(t, tt.want, received())
+        })
+    }
+}
+
+// TestReplayAfterRestart проверяет, что история и нумерация переживают перезапуск брокера
+func TestReplayAfterRestart(t *testing.T) {
+    dir := t.TempDir()
+
+    pubSub, st := newStorePubSub(t, dir)
+    require.NoError(t, pubSub.Publish("orders", "a"))
+    require.NoError(t, pubSub.Publish("orders", []byte("b")))
+    require.NoError(t, pubSub.Close(context.Background()))
+    require.NoError(t, st.Close())
+
+    pubSub, _ = newStorePubSub(t, dir)
+    var received []*Message
+    _, err := pubSub.SubscribeMsg("orders", "", func(msg *Message) {
+        received = append(received, msg)
+    }, WithStartPosition(StartEarliest()))
+    require.NoError(t, err)
+    require.NoError(t, pubSub.Publish("orders", "c"))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    require.Len(t, received, 3)
+    assert.Equal(t, "a", received[0].Data, "Строка восстанавливается строкой")
+    assert.Equal(t, []byte("b"), received[1].Data)
+    assert.Equal(t, "c", received[2].Data)
+    for i, msg := range received {
+        assert.Equal(t, uint64(i+1), msg.Seq)
+        assert.Equal(t, "orders", msg.Subject)
+        assert.False(t, msg.Time.IsZero())
+    }
+}
+
+// TestPublishMsg проверяет, что метаданные сообщения сохраняются в журнал
+// и записи старого формата по-прежнему читаются
+func TestPublishMsg(t *testing.T) {
+    dir := t.TempDir()
+
+    pubSub, st := newStorePubSub(t, dir)
+    live := make(chan *Message, 1)
+    _, err := pubSub.SubscribeMsg("images", "", func(msg *Message) {
+        live <- msg
+    })
+    require.NoError(t, err)
+
+    sent := &Message{
+        Subject:     "images",
+        Headers:     map[string]string{"source": "camera-1", "encoding": ""},
+        ContentType: "image/png",
+        Data:        []byte{0x89, 'P', 'N', 'G', 0xff},
+    }
+    require.NoError(t, pubSub.PublishMsg(sent))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    got := <-live
+    assert.NotEqual(t, uuid.Nil, got.ID, "Брокер назначает идентификатор")
+    assert.Equal(t, uuid.Nil, sent.ID, "Исходное сообщение не меняется")
+
+    // Запись старого формата: только тип данных и сами данные
+    require.NoError(t, st.Append(&store.Record{
+        Subject: "images",
+        Seq:     2,
+        Time:    time.Now(),
+        Data:    append([]byte{dataString}, "legacy"...),
+    }))
+    require.NoError(t, st.Close())
+
+    pubSub, _ = newStorePubSub(t, dir)
+    var received []*Message
+    _, err = pubSub.SubscribeMsg("images", "", func(msg *Message) {
+        received = append(received, msg)
+    }, WithStartPosition(StartEarliest()))
+    require.NoError(t, err)
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    require.Len(t, received, 2)
+    assert.Equal(t, got.ID, received[0].ID)
+    assert.Equal(t, sent.Headers, received[0].Headers)
+    assert.Equal(t, sent.ContentType, received[0].ContentType)
+    assert.Equal(t, sent.Data, received[0].Data)
+    assert.Equal(t, "legacy", received[1].Data)
+    assert.Equal(t, uuid.Nil, received[1].ID)
+}
+
+// TestReplayErrors проверяет ошибки чтения истории и сохранения сообщений
+func TestReplayErrors(t *testing.T) {
+    pubSub := NewSubPub()
+    _, err := pubSub.Subscribe("orders", func(msg interface{}) {}, WithStartPosition(StartEarliest()))
+    assert.ErrorIs(t, err, ErrNoStore)
+
+    pubSub, _ = newStorePubSub(t, t.TempDir())
+    assert.ErrorIs(t, pubSub.Publish("orders", 42), ErrNotPersistable)
+
+    // Несохраненное сообщение не занимает номер
+    require.NoError(t, pubSub.Publish("orders", "a"))
+    received := collect(t, pubSub, "orders", WithStartPosition(StartEarliest()))
+    require.NoError(t, pubSub.Close(context.Background()))
+    assert.Equal(t, []string{"1:a"}, received())
+}
+
+// TestReplayUnreadableRecord проверяет, что нечитаемая запись журнала
+// пропускается с ошибкой, а не теряется молча
+func TestReplayUnreadableRecord(t *testing.T) {
+    st, err := store.Open(t.TempDir(), store.Options{})
+    require.NoError(t, err)
+    defer st.Close()
+    require.NoError(t, st.Append(&store.Record{Subject: "orders", Seq: 1, Time: time.Now(), Data: []byte{0xff}}))
+
+    var mu sync.Mutex
+    var reported []error
+    pubSub := NewSubPub(WithStore(st), WithErrorHandler(func(err error) {
+        mu.Lock()
+        defer mu.Unlock()
+        reported = append(reported, err)
+    }))
+    require.NoError(t, pubSub.Publish("orders", "a"))
+
+    received := collect(t, pubSub, "orders", WithStartPosition(StartEarliest()))
+    require.NoError(t, pubSub.Close(context.Background()))
+    assert.Equal(t, []string{"2:a"}, received())
+
+    require.Len(t, reported, 1)
+    var replayErr *ReplayError
+    require.ErrorAs(t, reported[0], &replayErr)
+    assert.Equal(t, "orders", replayErr.Subject)
+    assert.Equal(t, uint64(1), replayErr.Seq)
+}
+
+// copyDir копирует каталог журнала так, как его увидел бы процесс после сбоя:
+// файлы читаются, пока в них продолжают писать
+func copyDir(t *testing.T, src, dst string) {
+    t.Helper()
+    err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(src, path)
+        if err != nil {
+            return err
+        }
+        target := filepath.Join(dst, rel)
+        if d.IsDir() {
+            return os.MkdirAll(target, 0o755)
+        }
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        return os.WriteFile(target, data, 0o644)
+    })
+    require.NoError(t, err)
+}
+
+// TestCrashRecovery проверяет, что при политике SyncAlways ни одно
+// подтвержденное сообщение не теряется при сбое посреди записи
+func TestCrashRecovery(t *testing.T) {
+    dir := t.TempDir()
+    st, err := store.Open(dir, store.Options{Sync: store.SyncAlways, MaxSegmentBytes: 512})
+    require.NoError(t, err)
+    pubSub := NewSubPub(WithStore(st))
+
+    var acked atomic.Uint64
+    stop := make(chan struct{})
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for n := 1; ; n++ {
+            select {
+            case <-stop:
+                return
+            default:
+            }
+            subject := fmt.Sprintf("orders.%d", n%3)
+            if err := pubSub.Publish(subject, fmt.Sprintf("message-%d", n)); err != nil {
+                return
+            }
+            acked.Store(uint64(n))
+        }
+    }()
+
+    // Снимок каталога посреди публикаций - состояние диска в момент сбоя
+    require.Eventually(t, func() bool { return acked.Load() > 50 }, 5*time.Second, time.Millisecond)
+    ackedBeforeCrash := acked.Load()
+    crashDir := t.TempDir()
+    copyDir(t, dir, crashDir)
+
+    close(stop)
+    <-done
+    require.NoError(t, pubSub.Close(context.Background()))
+    require.NoError(t, st.Close())
+
+    // Перезапуск на снимке: журнал восстанавливается и отдает всю историю
+    pubSub, _ = newStorePubSub(t, crashDir)
+    var seqs []uint64
+    _, err = pubSub.SubscribeMsg(">", "", func(msg *Message) {
+        if msg.Data != "after-restart" {
+            assert.Equal(t, fmt.Sprintf("message-%d", msg.Seq), msg.Data)
+        }
+        seqs = append(seqs, msg.Seq)
+    }, WithStartPosition(StartEarliest()))
+    require.NoError(t, err)
+    require.NoError(t, pubSub.Publish("orders.0", "after-restart"))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    require.GreaterOrEqual(t, uint64(len(seqs)), ackedBeforeCrash+1)
+    for i := uint64(0); i < ackedBeforeCrash; i++ {
+        assert.Equal(t, i+1, seqs[i], "Подтвержденное сообщение потеряно")
+    }
+}
+
+type order struct {
+    ID    int
+    Items []string
+}
+
+// TestTyped проверяет типизированную публикацию через разные кодеки
+func TestTyped(t *testing.T) {
+    t.Run("JSON", func(t *testing.T) {
+        testTypedRoundTrip(t, JSONCodec, order{ID: 1, Items: []string{"a", "b"}}, assert.Equal)
+    })
+    t.Run("Gob", func(t *testing.T) {
+        testTypedRoundTrip(t, GobCodec, order{ID: 2, Items: []string{"c"}}, assert.Equal)
+    })
+    t.Run("Protobuf", func(t *testing.T) {
+        want := wrapperspb.String("hello")
+        testTypedRoundTrip(t, ProtoCodec, want, func(t assert.TestingT, want, got interface{}, _ ...interface{}) bool {
+            return assert.True(t, proto.Equal(want.(proto.Message), got.(proto.Message)))
+        })
+    })
+    // Байты и строки тоже проходят через кодек, а не передаются закодированными
+    t.Run("Байты", func(t *testing.T) {
+        testTypedRoundTrip(t, JSONCodec, []byte("hello"), assert.Equal)
+    })
+    t.Run("Строка", func(t *testing.T) {
+        testTypedRoundTrip(t, GobCodec, "hello", assert.Equal)
+    })
+    t.Run("Любое значение", func(t *testing.T) {
+        testTypedRoundTrip[any](t, JSONCodec, map[string]interface{}{"id": 1.0}, assert.Equal)
+    })
+}
+
+func testTypedRoundTrip[T any](t *testing.T, codec Codec, want T, equal assert.ComparisonAssertionFunc) {
+    pubSub := NewSubPub()
+    typed := NewTyped[T](pubSub, codec)
+
+    received := make(chan T, 1)
+    _, err := typed.Subscribe("orders", func(v T) {
+        received <- v
+    })
+    require.NoError(t, err)
+
+    // Сообщение публикуется байтами, поэтому его можно сохранить и отдать по gRPC
+    var raw interface{}
+    _, err = pubSub.SubscribeMsg("orders", "", func(msg *Message) {
+        raw = msg.Data
+    })
+    require.NoError(t, err)
+
+    require.NoError(t, typed.Publish("orders", want))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    equal(t, want, <-received)
+    assert.IsType(t, []byte(nil), raw)
+}
+
+// TestTypedDecode проверяет разбор сообщений, опубликованных в обход Typed
+func TestTypedDecode(t *testing.T) {
+    pubSub := NewSubPub()
+
+    var failed []interface{}
+    typed := NewTyped[order](pubSub, JSONCodec, WithDecodeErrorHandler(func(msg *Message, err error) {
+        assert.Error(t, err)
+        failed = append(failed, msg.Data)
+    }))
+
+    var received []order
+    _, err := typed.Subscribe("orders", func(v order) {
+        received = append(received, v)
+    })
+    require.NoError(t, err)
+
+    require.NoError(t, pubSub.Publish("orders", order{ID: 1}))
+    require.NoError(t, pubSub.Publish("orders", `{"ID":2}`))
+    require.NoError(t, pubSub.Publish("orders", "not json"))
+    require.NoError(t, pubSub.Publish("orders", 42))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, []order{{ID: 1}, {ID: 2}}, received)
+    assert.Equal(t, []interface{}{"not json", 42}, failed)
+}
+
+// TestHandlerPanic проверяет, что паника обработчика не завершает процесс
+// и не мешает другим подписчикам
+func TestHandlerPanic(t *testing.T) {
+    var mu sync.Mutex
+    var reported []error
+    pubSub := NewSubPub(WithErrorHandler(func(err error) {
+        mu.Lock()
+        defer mu.Unlock()
+        reported = append(reported, err)
+    }))
+
+    var received []interface{}
+    sub, err := pubSub.Subscribe("orders", func(msg interface{}) {
+        if msg == "bad" {
+            panic("broken handler")
+        }
+        received = append(received, msg)
+    })
+    require.NoError(t, err)
+
+    var other []interface{}
+    _, err = pubSub.Subscribe("orders", func(msg interface{}) {
+        other = append(other, msg)
+    })
+    require.NoError(t, err)
+
+    for _, msg := range []string{"a", "bad", "b"} {
+        require.NoError(t, pubSub.Publish("orders", msg))
+    }
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, []interface{}{"a", "b"}, received, "Подписчик продолжает работать после паники")
+    assert.Equal(t, []interface{}{"a", "bad", "b"}, other)
+    assert.NoError(t, sub.Err())
+    assert.Equal(t, uint64(1), pubSub.Stats().Panics)
+
+    require.Len(t, reported, 1)
+    var panicErr *PanicError
+    require.ErrorAs(t, reported[0], &panicErr)
+    assert.Equal(t, "broken handler", panicErr.Value)
+    assert.Equal(t, uint64(2), panicErr.Seq)
+    assert.Equal(t, "orders", panicErr.Subject)
+    assert.Contains(t, string(panicErr.Stack), "TestHandlerPanic")
+}
+
+// TestPanicLimit проверяет отключение подписчика, который паникует подряд
+func TestPanicLimit(t *testing.T) {
+    tests := []struct {
+        name         string
+        messages     []string
+        wantErr      error
+        wantReceived []string
+    }{
+        {
+            name:         "Паники подряд",
+            messages:     []string{"ok", "bad", "bad", "bad", "ok"},
+            wantErr:      ErrTooManyPanics,
+            wantReceived: []string{"ok"},
+        },
+        {
+            name:         "Успешная обработка сбрасывает счетчик",
+            messages:     []string{"bad", "bad", "ok", "bad", "bad", "ok"},
+            wantReceived: []string{"ok", "ok"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub(WithPanicLimit(3), WithErrorHandler(func(error) {}))
+
+            var received []string
+            sub, err := pubSub.Subscribe("orders", func(msg interface{}) {
+                if msg == "bad" {
+                    panic("bad message")
+                }
+                received = append(received, msg.(string))
+            })
+            require.NoError(t, err)
+
+            for _, msg := range tt.messages {
+                require.NoError(t, pubSub.Publish("orders", msg))
+            }
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            <-sub.Done()
+            assert.Equal(t, tt.wantReceived, received)
+            if tt.wantErr == nil {
+                assert.NoError(t, sub.Err())
+                return
+            }
+            assert.ErrorIs(t, sub.Err(), tt.wantErr)
+            var panicErr *PanicError
+            assert.ErrorAs(t, sub.Err(), &panicErr)
+            assert.Equal(t, uint64(1), pubSub.Stats().Disconnected)
+        })
+    }
+}
+
+// recordingMetrics запоминает события брокера
+type recordingMetrics struct {
+    mu            sync.Mutex
+    published     map[string]int
+    delivered     map[string]int
+    dropped       map[string]int
+    subscriptions map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+    return &recordingMetrics{
+        published:     make(map[string]int),
+        delivered:     make(map[string]int),
+        dropped:       make(map[string]int),
+        subscriptions: make(map[string]int),
+    }
+}
+
+func (m *recordingMetrics) add(counter map[string]int, key string, delta int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    counter[key] += delta
+}
+
+func (m *recordingMetrics) MessagePublished(subject string) { m.add(m.published, subject, 1) }
+func (m *recordingMetrics) MessageDelivered(subject string, d time.Duration) {
+    m.add(m.delivered, subject, 1)
+}
+func (m *recordingMetrics) MessageDropped(subject string)      { m.add(m.dropped, subject, 1) }
+func (m *recordingMetrics) SubscriptionStarted(pattern string) { m.add(m.subscriptions, pattern, 1) }
+func (m *recordingMetrics) SubscriptionEnded(pattern string)   { m.add(m.subscriptions, pattern, -1) }
+
+// TestMetrics проверяет события, которые брокер передает в Metrics
+func TestMetrics(t *testing.T) {
+    metrics := newRecordingMetrics()
+    pubSub := NewSubPub(WithMetrics(metrics), WithQueueSize(1))
+
+    _, err := pubSub.Subscribe("orders.>", func(msg interface{}) {})
+    require.NoError(t, err)
+
+    // Подписчик, который не разбирает очередь
+    started := make(chan struct{}, 1)
+    release := make(chan struct{})
+    slow, err := pubSub.Subscribe("orders.eu", func(msg interface{}) {
+        started <- struct{}{}
+        <-release
+    }, WithOverflowPolicy(DropNewest))
+    require.NoError(t, err)
+
+    require.NoError(t, pubSub.Publish("orders.eu", "1"))
+    <-started
+    require.NoError(t, pubSub.Publish("orders.eu", "2"))
+    require.NoError(t, pubSub.Publish("orders.eu", "3"))
+    require.NoError(t, pubSub.Publish("orders.us", "4"))
+
+    metrics.mu.Lock()
+    assert.Equal(t, map[string]int{"orders.>": 1, "orders.eu": 1}, metrics.subscriptions)
+    metrics.mu.Unlock()
+    assert.Equal(t, 1, pubSub.QueueDepths()["orders.eu"], "Второе сообщение ждет в очереди")
+
+    close(release)
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, map[string]int{"orders.eu": 3, "orders.us": 1}, metrics.published)
+    assert.Equal(t, map[string]int{"orders.eu": 5, "orders.us": 1}, metrics.delivered)
+    assert.Equal(t, map[string]int{"orders.eu": 1}, metrics.dropped)
+    assert.Equal(t, uint64(1), slow.Dropped())
+    assert.Equal(t, map[string]int{"orders.>": 0, "orders.eu": 0}, metrics.subscriptions)
+}
+
+// TestTracing проверяет, что брокер продолжает трассу из заголовков сообщения
+func TestTracing(t *testing.T) {
+    exporter := tracetest.NewInMemoryExporter()
+    tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+    pubSub := NewSubPub(WithTracerProvider(tp))
+
+    parent, span := tp.Tracer("test").Start(context.Background(), "publisher")
+    span.End()
+
+    var mu sync.Mutex
+    var handled []trace.SpanContext
+    for i := 0; i < 2; i++ {
+        _, err := pubSub.SubscribeMsg("orders", "", func(msg *Message) {
+            mu.Lock()
+            defer mu.Unlock()
+            handled = append(handled, trace.SpanContextFromContext(msg.Context()))
+        })
+        require.NoError(t, err)
+    }
+
+    headers := map[string]string{"source": "billing"}
+    require.NoError(t, pubSub.PublishMsg(&Message{
+        Subject: "orders",
+        Headers: InjectTrace(parent, headers),
+        Data:    "created",
+    }))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    assert.Equal(t, map[string]string{"source": "billing"}, headers, "Заголовки издателя не меняются")
+
+    var enqueue tracetest.SpanStub
+    var handles []tracetest.SpanStub
+    for _, stub := range exporter.GetSpans() {
+        switch stub.Name {
+        case "subpub.enqueue orders":
+            enqueue = stub
+        case "subpub.handle orders":
+            handles = append(handles, stub)
+        }
+    }
+    assert.Equal(t, span.SpanContext().SpanID(), enqueue.Parent.SpanID())
+
+    // У каждого подписчика свой спан обработки
+    require.Len(t, handled, 2)
+    require.Len(t, handles, 2)
+    assert.NotEqual(t, handled[0].SpanID(), handled[1].SpanID())
+    for _, sc := range handled {
+        assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+    }
+    for _, handle := range handles {
+        assert.Equal(t, enqueue.SpanContext.SpanID(), handle.Parent.SpanID())
+    }
+}
+
+// TestLogger проверяет, что без обработчика ошибок паники пишутся в лог брокера
+func TestLogger(t *testing.T) {
+    var buf bytes.Buffer
+    pubSub := NewSubPub(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+    _, err := pubSub.Subscribe("orders", func(msg interface{}) {
+        panic("broken handler")
+    })
+    require.NoError(t, err)
+    require.NoError(t, pubSub.Publish("orders", "a"))
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    logs := buf.String()
+    assert.Contains(t, logs, `msg="handler panicked"`)
+    assert.Contains(t, logs, "subject=orders")
+    assert.Contains(t, logs, `panic="broken handler"`)
+    assert.Contains(t, logs, "stack=")
+}
+
+// TestErrors проверяет, что ошибки брокера распознаются через errors.Is
+func TestErrors(t *testing.T) {
+    handler := func(msg interface{}) {}
+
+    tests := []struct {
+        name    string
+        call    func(ps *PubSub) error
+        closed  bool
+        wantErr error
+    }{
+        {
+            name: "Публикация в закрытый брокер",
+            call: func(ps *PubSub) error {
+                return ps.Publish("orders", "a")
+            },
+            closed:  true,
+            wantErr: ErrClosed,
+        },
+        {
+            name: "Подписка на закрытый брокер",
+            call: func(ps *PubSub) error {
+                _, err := ps.Subscribe("orders", handler)
+                return err
+            },
+            closed:  true,
+            wantErr: ErrClosed,
+        },
+        {
+            name: "Публикация в шаблон темы",
+            call: func(ps *PubSub) error {
+                return ps.Publish("orders.*", "a")
+            },
+            wantErr: ErrInvalidSubject,
+        },
+        {
+            name: "Шаблон > не в конце",
+            call: func(ps *PubSub) error {
+                _, err := ps.Subscribe("orders.>.created", handler)
+                return err
+            },
+            wantErr: ErrInvalidSubject,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub()
+            if tt.closed {
+                require.NoError(t, pubSub.Close(context.Background()))
+            }
+
+            err := tt.call(pubSub)
+            assert.ErrorIs(t, err, tt.wantErr)
+            assert.NotErrorIs(t, err, context.Canceled)
+        })
+    }
+}
+
+// TestValidateSubject проверяет правила именования тем
+func TestValidateSubject(t *testing.T) {
+    tests := []struct {
+        name       string
+        subject    string
+        pattern    bool
+        wantReason string
+    }{
+        {name: "Простая тема", subject: "orders"},
+        {name: "Иерархическая тема", subject: "orders.eu.created"},
+        {name: "UUID", subject: "b9bd9eff-615e-428c-9654-b11adb80b080"},
+        {name: "Русская тема", subject: "заказы.новые"},
+        {name: "Системная тема", subject: "$DLQ.orders"},
+        {name: "Шаблон *", subject: "orders.*.created", pattern: true},
+        {name: "Шаблон >", subject: "orders.>", pattern: true},
+        {name: "Пустая тема", subject: "", wantReason: "subject is empty"},
+        {name: "Пустой токен", subject: "orders..created", wantReason: "token 2 is empty"},
+        {name: "Точка в конце", subject: "orders.", wantReason: "token 2 is empty"},
+        {name: "Пробел", subject: "orders eu", wantReason: `token 1 contains invalid character ' '`},
+        {name: "$ не в начале", subject: "orders.$DLQ", wantReason: `token 2 contains invalid character '$'`},
+        {name: "Пустая системная тема", subject: "$.orders", wantReason: "system subject has empty name"},
+        {name: "Шаблон в публикации", subject: "orders.*", wantReason: "wildcards are not allowed in a published subject"},
+        {name: "> не в конце", subject: "orders.>.created", pattern: true, wantReason: `">" must be the last token`},
+        {name: "Шаблон внутри токена", subject: "orders.eu*", pattern: true, wantReason: `token 2 contains invalid character '*'`},
+        {name: "Слишком длинная тема", subject: strings.Repeat("a", MaxSubjectLength+1), wantReason: "subject is longer than 256 bytes"},
+        {name: "Слишком глубокая тема", subject: strings.Repeat("a.", MaxSubjectTokens) + "a", wantReason: "subject has more than 16 tokens"},
+        {name: "Некорректный UTF-8", subject: "orders.\xff", wantReason: "subject is not valid UTF-8"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            validate := ValidateSubject
+            if tt.pattern {
+                validate = ValidatePattern
+            }
+
+            err := validate(tt.subject)
+            if tt.wantReason == "" {
+                assert.NoError(t, err)
+                return
+            }
+            assert.ErrorIs(t, err, ErrInvalidSubject)
+            var subjectErr *SubjectError
+            require.ErrorAs(t, err, &subjectErr)
+            assert.Equal(t, tt.wantReason, subjectErr.Reason)
+        })
+    }
+}
+
+// FuzzValidateSubject проверяет, что корректная тема является и корректным
+// шаблоном, совпадающим с самой темой
+func FuzzValidateSubject(f *testing.F) {
+    for _, seed := range []string{"orders", "orders.eu.created", "$DLQ.orders", "orders.*", "a..b", "", "заказы"} {
+        f.Add(seed)
+    }
+
+    f.Fuzz(func(t *testing.T, subject string) {
+        if err := ValidateSubject(subject); err != nil {
+            assert.ErrorIs(t, err, ErrInvalidSubject)
+            return
+        }
+        assert.LessOrEqual(t, len(subject), MaxSubjectLength)
+        assert.LessOrEqual(t, len(tokenize(subject)), MaxSubjectTokens)
+        assert.NoError(t, ValidatePattern(subject))
+        assert.True(t, matchSubject(subject, subject))
+    })
+}
+
+// collectMatcher собирает подписки, найденные деревом
+type collectMatcher struct {
+    subs []*subscription
+}
+
+func (m *collectMatcher) subscriber(sub *subscription) {
+    m.subs = append(m.subs, sub)
+}
+
+func (m *collectMatcher) group(name string, members []*subscription) {
+    m.subs = append(m.subs, members...)
+}
+
+// FuzzMatchSubject сверяет поиск по реестру подписок с matchSubject
+func FuzzMatchSubject(f *testing.F) {
+    seeds := [][2]string{
+        {"orders.*", "orders.eu"},
+        {"orders.>", "orders.eu.created"},
+        {"orders.>", "orders"},
+        {"*.eu.*", "orders.eu.created"},
+        {"orders", "orders.eu"},
+        {">", "$DLQ.orders"},
+    }
+    for _, seed := range seeds {
+        f.Add(seed[0], seed[1])
+    }
+
+    f.Fuzz(func(t *testing.T, pattern, subject string) {
+        if ValidatePattern(pattern) != nil || ValidateSubject(subject) != nil {
+            return
+        }
+
+        r := newRegistry()
+        sub := &subscription{subject: pattern, id: uuid.New()}
+        r.insert(pattern, sub)
+
+        var m collectMatcher
+        r.match(subject, &m)
+        assert.Equal(t, matchSubject(pattern, subject), len(m.subs) == 1,
+            "pattern %q, subject %q", pattern, subject)
+
+        r.remove(sub)
+        assert.True(t, r.empty())
+    })
+}
+
+// TestConcurrentSubjects проверяет, что параллельные публикации, подписки и
+// отписки на разные темы не теряют сообщения
+func TestConcurrentSubjects(t *testing.T) {
+    const (
+        publishers = 8
+        messages   = 200
+    )
+    pubSub := NewSubPub()
+
+    var wildcard atomic.Int64
+    _, err := pubSub.Subscribe("load.>", func(msg interface{}) {
+        wildcard.Add(1)
+    })
+    require.NoError(t, err)
+
+    counts := make([]atomic.Int64, publishers)
+    var wg sync.WaitGroup
+    for i := 0; i < publishers; i++ {
+        subject := fmt.Sprintf("load.%d", i)
+        _, err := pubSub.Subscribe(subject, func(msg interface{}) {
+            counts[i].Add(1)
+        })
+        require.NoError(t, err)
+
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < messages; j++ {
+                assert.NoError(t, pubSub.Publish(subject, j))
+            }
+        }()
+        // Подписки и отписки на соседние темы идут одновременно с публикациями
+        go func() {
+            defer wg.Done()
+            for j := 0; j < messages/10; j++ {
+                sub, err := pubSub.Subscribe(fmt.Sprintf("load.%d.churn.*", i), func(msg interface{}) {})
+                assert.NoError(t, err)
+                sub.Unsubscribe()
+            }
+        }()
+    }
+    wg.Wait()
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    for i := range counts {
+        assert.Equal(t, int64(messages), counts[i].Load(), "тема load.%d", i)
+    }
+    assert.Equal(t, int64(publishers*messages), wildcard.Load())
+}
+
+// TestReplayConcurrentPublish проверяет, что подписка с историей во время
+// публикаций получает каждое сообщение ровно один раз
+func TestReplayConcurrentPublish(t *testing.T) {
+    const messages = 300
+    pubSub, _ := newStorePubSub(t, t.TempDir())
+
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < messages; i++ {
+            assert.NoError(t, pubSub.Publish("orders", "x"))
+        }
+    }()
+
+    var mu sync.Mutex
+    seen := make(map[uint64]int)
+    _, err := pubSub.SubscribeMsg("orders", "", func(msg *Message) {
+        mu.Lock()
+        seen[msg.Seq]++
+        mu.Unlock()
+    }, WithStartPosition(StartEarliest()))
+    require.NoError(t, err)
+    wg.Wait()
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    require.Len(t, seen, messages)
+    for seq := uint64(1); seq <= messages; seq++ {
+        assert.Equal(t, 1, seen[seq], "сообщение %d", seq)
+    }
+}
+
+// benchSubjects - число тем в бенчмарках, по подписчику на каждую
+const benchSubjects = 10000
+
+func newBenchPubSub(b *testing.B, patterns ...string) (*PubSub, []string) {
+    b.Helper()
+    pubSub := NewSubPub()
+    b.Cleanup(func() { pubSub.Close(context.Background()) })
+
+    subjects := make([]string, benchSubjects)
+    for i := range subjects {
+        subjects[i] = fmt.Sprintf("bench.%d.events", i)
+        // Переполнение очереди не должно тормозить издателя
+        _, err := pubSub.Subscribe(subjects[i], func(msg interface{}) {}, WithOverflowPolicy(DropNewest))
+        require.NoError(b, err)
+    }
+    for _, pattern := range patterns {
+        _, err := pubSub.Subscribe(pattern, func(msg interface{}) {}, WithOverflowPolicy(DropNewest))
+        require.NoError(b, err)
+    }
+    return pubSub, subjects
+}
+
+// BenchmarkPublishParallel показывает масштабирование публикаций по
+// GOMAXPROCS: go test -bench PublishParallel -cpu 1,2,4,8 ./subpub
+func BenchmarkPublishParallel(b *testing.B) {
+    cases := []struct {
+        name     string
+        patterns []string
+        shared   bool
+    }{
+        {name: "разные темы"},
+        {name: "разные темы с шаблоном", patterns: []string{"bench.*.audit"}},
+        {name: "одна тема", shared: true},
+    }
+
+    for _, bc := range cases {
+        b.Run(bc.name, func(b *testing.B) {
+            pubSub, subjects := newBenchPubSub(b, bc.patterns...)
+            var next atomic.Uint64
+
+            b.ReportAllocs()
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                // Каждая горутина публикует в свою последовательность тем
+                i := int(next.Add(1)) * 7919
+                for pb.Next() {
+                    subject := subjects[0]
+                    if !bc.shared {
+                        subject = subjects[i%len(subjects)]
+                        i++
+                    }
+                    if err := pubSub.Publish(subject, "payload"); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            })
+        })
+    }
+}
+
+// BenchmarkSubscribeParallel измеряет подписку и отписку под нагрузкой
+func BenchmarkSubscribeParallel(b *testing.B) {
+    cases := []struct {
+        name    string
+        pattern string
+    }{
+        {name: "тема", pattern: "bench.%d.events"},
+        {name: "шаблон", pattern: "bench.%d.*"},
+    }
+
+    for _, bc := range cases {
+        b.Run(bc.name, func(b *testing.B) {
+            pubSub, _ := newBenchPubSub(b)
+            var next atomic.Uint64
+
+            b.ReportAllocs()
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    subject := fmt.Sprintf(bc.pattern, next.Add(1)%benchSubjects)
+                    sub, err := pubSub.Subscribe(subject, func(msg interface{}) {})
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                    sub.Unsubscribe()
+                }
+            })
+        })
+    }
+}
+
+// TestWorkerPool проверяет жизненный цикл подписок, обслуживаемых пулом воркеров
+func TestWorkerPool(t *testing.T) {
+    t.Run("Горутины не растут с числом подписок", func(t *testing.T) {
+        pubSub := NewSubPub(WithWorkers(4))
+        before := runtime.NumGoroutine()
+
+        var received atomic.Int64
+        for i := 0; i < 100; i++ {
+            _, err := pubSub.Subscribe(fmt.Sprintf("orders.%d", i), func(msg interface{}) {
+                received.Add(1)
+            })
+            require.NoError(t, err)
+        }
+        assert.Less(t, runtime.NumGoroutine()-before, 10)
+
+        for i := 0; i < 100; i++ {
+            require.NoError(t, pubSub.Publish(fmt.Sprintf("orders.%d", i), "a"))
+        }
+        require.NoError(t, pubSub.Close(context.Background()))
+        assert.Equal(t, int64(100), received.Load())
+    })
+
+    t.Run("Отписка", func(t *testing.T) {
+        pubSub := NewSubPub(WithWorkers(1))
+        sub, err := pubSub.Subscribe("orders", func(msg interface{}) {})
+        require.NoError(t, err)
+
+        sub.Unsubscribe()
+        select {
+        case <-sub.Done():
+        case <-time.After(time.Second):
+            t.Fatal("Подписка не завершилась после отписки")
+        }
+        require.NoError(t, pubSub.Close(context.Background()))
+    })
+
+    t.Run("Паники обработчика", func(t *testing.T) {
+        pubSub := NewSubPub(WithWorkers(1), WithPanicLimit(2), WithErrorHandler(func(error) {}))
+        sub, err := pubSub.Subscribe("orders", func(msg interface{}) {
+            panic("bad message")
+        })
+        require.NoError(t, err)
+
+        for i := 0; i < 5; i++ {
+            require.NoError(t, pubSub.Publish("orders", "bad"))
+        }
+        <-sub.Done()
+        assert.ErrorIs(t, sub.Err(), ErrTooManyPanics)
+        require.NoError(t, pubSub.Close(context.Background()))
+    })
+
+    t.Run("История из журнала", func(t *testing.T) {
+        st, err := store.Open(t.TempDir(), store.Options{})
+        require.NoError(t, err)
+        t.Cleanup(func() { st.Close() })
+        pubSub := NewSubPub(WithStore(st), WithWorkers(1))
+
+        require.NoError(t, pubSub.Publish("orders", "a"))
+        require.NoError(t, pubSub.Publish("orders", "b"))
+        received := collect(t, pubSub, "orders", WithStartPosition(StartEarliest()))
+        require.NoError(t, pubSub.Publish("orders", "c"))
+        require.NoError(t, pubSub.Close(context.Background()))
+
+        assert.Equal(t, []string{"1:a", "2:b", "3:c"}, received())
+    })
+}
+
+// BenchmarkDispatch сравнивает горутину на подписку с пулом воркеров:
+// выделения памяти на публикацию и p99 задержки от публикации до обработчика
+func BenchmarkDispatch(b *testing.B) {
+    const subscribers = 1000
+
+    cases := []struct {
+        name    string
+        workers int
+    }{
+        {name: "горутина на подписку"},
+        {name: "пул из 4 воркеров", workers: 4},
+        {name: "пул по числу ядер", workers: runtime.GOMAXPROCS(0)},
+    }
+
+    for _, bc := range cases {
+        b.Run(bc.name, func(b *testing.B) {
+            pubSub := NewSubPub(WithWorkers(bc.workers))
+
+            latencies := make([]time.Duration, b.N)
+            var handled atomic.Int64
+            for i := 0; i < subscribers; i++ {
+                _, err := pubSub.SubscribeMsg(fmt.Sprintf("bench.%d", i), "", func(msg *Message) {
+                    latencies[msg.Seq-1] = time.Since(msg.Time)
+                    handled.Add(1)
+                })
+                require.NoError(b, err)
+            }
+
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if err := pubSub.Publish(fmt.Sprintf("bench.%d", i%subscribers), "payload"); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            require.NoError(b, pubSub.Close(context.Background()))
+            b.StopTimer()
+
+            require.Equal(b, int64(b.N), handled.Load())
+            slices.Sort(latencies)
+            b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+        })
+    }
+}
+
+// TestRetained проверяет доставку сохраненных значений тем новым подписчикам
+func TestRetained(t *testing.T) {
+    type publish struct {
+        subject string
+        data    string
+        retain  bool
+    }
+
+    tests := []struct {
+        name    string
+        publish []publish
+        clear   string
+        pattern string
+        group   string
+        workers int
+        want    []string
+    }{
+        {
+            name:    "Последнее значение темы",
+            publish: []publish{{"orders", "a", true}, {"orders", "b", true}},
+            pattern: "orders",
+            want:    []string{"2:b:retained", "3:live"},
+        },
+        {
+            name:    "Обычная публикация не заменяет значение",
+            publish: []publish{{"orders", "a", true}, {"orders", "b", false}},
+            pattern: "orders",
+            want:    []string{"1:a:retained", "3:live"},
+        },
+        {
+            name: "Шаблон получает значения в порядке публикации",
+            publish: []publish{
+                {"orders.us", "a", true},
+                {"orders.eu", "b", true},
+                {"users.eu", "c", true},
+            },
+            pattern: "orders.*",
+            want:    []string{"1:a:retained", "2:b:retained", "4:live"},
+        },
+        {
+            name:    "Очистка значений",
+            publish: []publish{{"orders", "a", true}},
+            clear:   "orders",
+            pattern: "orders",
+            want:    []string{"2:live"},
+        },
+        {
+            name:    "Участник очереди не получает значения",
+            publish: []publish{{"orders", "a", true}},
+            pattern: "orders",
+            group:   "workers",
+            want:    []string{"2:live"},
+        },
+        {
+            name:    "Пул воркеров",
+            publish: []publish{{"orders", "a", true}},
+            pattern: "orders",
+            workers: 1,
+            want:    []string{"1:a:retained", "2:live"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub(WithWorkers(tt.workers))
+            for _, p := range tt.publish {
+                require.NoError(t, pubSub.PublishMsg(&Message{Subject: p.subject, Data: p.data, Retain: p.retain}))
+            }
+            if tt.clear != "" {
+                n, err := pubSub.ClearRetained(tt.clear)
+                require.NoError(t, err)
+                assert.Equal(t, 1, n)
+            }
+
+            var mu sync.Mutex
+            var received []string
+            _, err := pubSub.SubscribeMsg(tt.pattern, tt.group, func(msg *Message) {
+                mu.Lock()
+                defer mu.Unlock()
+                entry := fmt.Sprintf("%d:%v", msg.Seq, msg.Data)
+                if msg.Retain {
+                    entry += ":retained"
+                }
+                received = append(received, entry)
+            })
+            require.NoError(t, err)
+
+            require.NoError(t, pubSub.Publish(tt.publish[0].subject, "live"))
+            require.NoError(t, pubSub.Close(context.Background()))
+
+            assert.Equal(t, tt.want, received)
+        })
+    }
+}
+
+// TestRetainedConcurrentPublish проверяет, что подписка во время публикаций
+// получает сохраненное значение и новые сообщения без пропусков и повторов
+func TestRetainedConcurrentPublish(t *testing.T) {
+    const messages = 500
+    pubSub := NewSubPub()
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < messages; i++ {
+            assert.NoError(t, pubSub.PublishMsg(&Message{Subject: "orders", Data: i, Retain: true}))
+        }
+    }()
+
+    var received []uint64
+    _, err := pubSub.SubscribeMsg("orders", "", func(msg *Message) {
+        received = append(received, msg.Seq)
+    })
+    require.NoError(t, err)
+    <-done
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    require.NotEmpty(t, received)
+    assert.Equal(t, uint64(messages), received[len(received)-1])
+    for i := 1; i < len(received); i++ {
+        assert.Equal(t, received[i-1]+1, received[i], "пропуск или повтор после %d", received[i-1])
+    }
+}
+
+func TestPublishBatch(t *testing.T) {
+    tests := []struct {
+        name      string
+        withStore bool
+    }{
+        {name: "В памяти"},
+        {name: "С журналом", withStore: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pubSub := NewSubPub()
+            if tt.withStore {
+                pubSub, _ = newStorePubSub(t, t.TempDir())
+            }
+            received := collect(t, pubSub, "orders.>")
+
+            errs := pubSub.PublishBatch([]*Message{
+                {Subject: "orders.eu", Data: "a"},
+                {Subject: "orders..us", Data: "bad subject"},
+                {Subject: "orders.us", Data: "b", Retain: true},
+                {Subject: "orders.eu", Data: "c"},
+            })
+            require.Len(t, errs, 4)
+            assert.NoError(t, errs[0])
+            assert.ErrorIs(t, errs[1], ErrInvalidSubject)
+            assert.NoError(t, errs[2])
+            assert.NoError(t, errs[3])
+
+            retained, ok := pubSub.Retained("orders.us")
+            require.True(t, ok)
+            assert.Equal(t, "b", retained.Data)
+
+            require.NoError(t, pubSub.Close(context.Background()))
+            assert.Equal(t, []string{"1:a", "2:b", "3:c"}, received())
+
+            errs = pubSub.PublishBatch([]*Message{{Subject: "orders.eu", Data: "late"}})
+            assert.ErrorIs(t, errs[0], ErrClosed)
+        })
+    }
+
+    t.Run("Сообщение, которое нельзя сохранить", func(t *testing.T) {
+        pubSub, _ := newStorePubSub(t, t.TempDir())
+        errs := pubSub.PublishBatch([]*Message{
+            {Subject: "orders", Data: 42},
+            {Subject: "orders", Data: "a"},
+        })
+        assert.ErrorIs(t, errs[0], ErrNotPersistable)
+        assert.NoError(t, errs[1])
+        require.NoError(t, pubSub.Close(context.Background()))
+    })
+}
+
+func TestRequest(t *testing.T) {
+    pubSub := NewSubPub()
+    defer pubSub.Close(context.Background())
+
+    _, err := pubSub.SubscribeMsg("echo", "", func(msg *Message) {
+        assert.Equal(t, "json", msg.ContentType)
+        require.NoError(t, pubSub.Reply(msg, fmt.Sprintf("re: %v", msg.Data)))
+    })
+    require.NoError(t, err)
+    // Молчащий подписчик не мешает получить ответ
+    _, err = pubSub.Subscribe("silent", func(msg interface{}) {})
+    require.NoError(t, err)
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    reply, err := pubSub.RequestMsg(ctx, &Message{Subject: "echo", Data: "ping", ContentType: "json"})
+    require.NoError(t, err)
+    assert.Equal(t, "re: ping", reply.Data)
+    assert.True(t, strings.HasPrefix(reply.Subject, inboxPrefix))
+
+    _, err = pubSub.Request(ctx, "nobody", "ping")
+    assert.ErrorIs(t, err, ErrNoResponders)
+
+    short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    _, err = pubSub.Request(short, "silent", "ping")
+    assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+    _, err = pubSub.Request(ctx, "echo.*", "ping")
+    assert.ErrorIs(t, err, ErrInvalidSubject)
+
+    assert.ErrorIs(t, pubSub.Reply(&Message{Subject: "echo"}, "pong"), ErrNoReplyTo)
+
+    // reply-to вне временных тем ответов не дает публиковать от имени обработчика
+    var leaked atomic.Int32
+    _, err = pubSub.Subscribe("billing.charge", func(msg interface{}) { leaked.Add(1) })
+    require.NoError(t, err)
+    forged := &Message{Subject: "echo", Headers: map[string]string{ReplyToHeader: "billing.charge"}}
+    assert.ErrorIs(t, pubSub.Reply(forged, "pong"), ErrNoReplyTo)
+    assert.Zero(t, leaked.Load())
+}
+
+func TestRequestMany(t *testing.T) {
+    pubSub := NewSubPub()
+    defer pubSub.Close(context.Background())
+
+    for _, name := range []string{"eu", "us", "asia"} {
+        _, err := pubSub.SubscribeMsg("inventory.query", "", func(msg *Message) {
+            require.NoError(t, pubSub.Reply(msg, name))
+        })
+        require.NoError(t, err)
+    }
+
+    tests := []struct {
+        name    string
+        n       int
+        timeout time.Duration
+        want    int
+    }{
+        {name: "Первые два ответа", n: 2, timeout: time.Second, want: 2},
+        {name: "Все ответы до дедлайна", n: 0, timeout: 100 * time.Millisecond, want: 3},
+        {name: "Ответов меньше, чем ждали", n: 5, timeout: 100 * time.Millisecond, want: 3},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+            defer cancel()
+
+            replies, err := pubSub.RequestMany(ctx, &Message{Subject: "inventory.query", Data: "sku-1"}, tt.n)
+            require.NoError(t, err)
+            assert.Len(t, replies, tt.want)
+        })
+    }
+}
+
+// TestRequestStore проверяет, что ответы не попадают в журнал
+func TestRequestStore(t *testing.T) {
+    pubSub, st := newStorePubSub(t, t.TempDir())
+
+    _, err := pubSub.SubscribeMsg("echo", "", func(msg *Message) {
+        // Обычные сообщения темы остаются без ответа
+        if msg.Headers[ReplyToHeader] != "" {
+            require.NoError(t, pubSub.Reply(msg, msg.Data))
+        }
+    })
+    require.NoError(t, err)
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    reply, err := pubSub.Request(ctx, "echo", "ping")
+    require.NoError(t, err)
+    assert.Equal(t, "ping", reply.Data)
+    require.NoError(t, pubSub.Publish("echo", "after"))
+
+    require.NoError(t, pubSub.Close(context.Background()))
+    assert.Equal(t, []string{"echo"}, st.Subjects())
+    assert.Equal(t, uint64(3), st.LastSeq(), "Номер ответа пропущен в журнале")
+}
+
+// TestRequestMetrics проверяет, что темы ответов не создают новых серий метрик
+func TestRequestMetrics(t *testing.T) {
+    metrics := newRecordingMetrics()
+    pubSub := NewSubPub(WithMetrics(metrics))
+
+    _, err := pubSub.SubscribeMsg("echo", "", func(msg *Message) {
+        require.NoError(t, pubSub.Reply(msg, msg.Data))
+    })
+    require.NoError(t, err)
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    for i := 0; i < 3; i++ {
+        _, err := pubSub.Request(ctx, "echo", "ping")
+        require.NoError(t, err)
+    }
+    require.NoError(t, pubSub.Close(context.Background()))
+
+    metrics.mu.Lock()
+    defer metrics.mu.Unlock()
+    assert.Equal(t, map[string]int{"echo": 3, "_INBOX": 3}, metrics.published)
+    assert.Equal(t, map[string]int{"echo": 0, "_INBOX": 0}, metrics.subscriptions)
+}
@@ -0,0 +1,129 @@
+package subpub
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/imhasandl/vk-internship/subpub"
+
+// propagator переносит контекст трассировки в заголовках сообщения
+// в формате W3C Trace Context (заголовки traceparent и tracestate)
+var propagator = propagation.TraceContext{}
+
+// WithTracerProvider включает трассировку сообщений: брокер продолжает трассу
+// из заголовков сообщения и создает спаны постановки в очереди и обработки.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(ps *PubSub) {
+		if tp != nil {
+			ps.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// InjectTrace записывает контекст трассировки ctx в заголовки. Исходная
+// карта не меняется, возвращается копия с заголовками трассировки.
+func InjectTrace(ctx context.Context, headers map[string]string) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return headers
+	}
+	for k, v := range headers {
+		if _, ok := carrier[k]; !ok {
+			carrier[k] = v
+		}
+	}
+	return carrier
+}
+
+// ExtractTrace восстанавливает контекст трассировки из заголовков
+func ExtractTrace(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// Context возвращает контекст обработки сообщения. Если трассировка включена,
+// он содержит спан обработчика, который можно продолжить.
+func (m *Message) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// startEnqueue начинает спан публикации и записывает его в заголовки сообщения
+func (ps *PubSub) startEnqueue(m *Message) trace.Span {
+	if ps.tracer == nil {
+		return nil
+	}
+	ctx, span := ps.tracer.Start(ExtractTrace(context.Background(), m.Headers), "subpub.enqueue "+m.Subject,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "subpub"),
+			attribute.String("messaging.destination.name", m.Subject),
+			attribute.String("messaging.message.id", m.ID.String()),
+		),
+	)
+	m.Headers = InjectTrace(ctx, m.Headers)
+	return span
+}
+
+// endEnqueue завершает спан публикации
+func endEnqueue(span trace.Span, m *Message, recipients int, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			attribute.Int64("subpub.sequence", int64(m.Seq)),
+			attribute.Int("subpub.recipients", recipients),
+		)
+	}
+	span.End()
+}
+
+// startHandle начинает спан обработки и возвращает копию сообщения с его контекстом
+func (s *subscription) startHandle(msg *Message) (*Message, trace.Span) {
+	tracer := s.ps.tracer
+	if tracer == nil {
+		return msg, nil
+	}
+	ctx, span := tracer.Start(ExtractTrace(context.Background(), msg.Headers), "subpub.handle "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "subpub"),
+			attribute.String("messaging.destination.name", msg.Subject),
+			attribute.String("messaging.message.id", msg.ID.String()),
+			attribute.Int64("subpub.sequence", int64(msg.Seq)),
+			attribute.String("subpub.subscription", s.subject),
+			attribute.String("subpub.group", s.group),
+		),
+	)
+	// Сообщение общее для всех подписчиков, контекст у каждого свой
+	delivered := *msg
+	delivered.ctx = ctx
+	return &delivered, span
+}
+
+func endHandle(span trace.Span, panicValue interface{}) {
+	if span == nil {
+		return
+	}
+	if panicValue != nil {
+		err := fmt.Errorf("panic: %v", panicValue)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,244 @@
+package subpub
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// tokenSeparator разделяет уровни иерархии темы: "orders.eu.created"
+	tokenSeparator = "."
+	// wildcardOne совпадает ровно с одним токеном: "orders.*"
+	wildcardOne = "*"
+	// wildcardTail совпадает с одним и более токенами в конце темы: "orders.>"
+	wildcardTail = ">"
+)
+
+// tokenize разбивает тему на токены
+func tokenize(subject string) []string {
+	return strings.Split(subject, tokenSeparator)
+}
+
+// matchSubject проверяет, совпадает ли тема с шаблоном
+func matchSubject(pattern, subject string) bool {
+	patternTokens, subjectTokens := tokenize(pattern), tokenize(subject)
+	for i, token := range patternTokens {
+		if token == wildcardTail {
+			return i == len(patternTokens)-1 && len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != wildcardOne && token != subjectTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// node - узел дерева подписок, каждый уровень дерева соответствует токену темы
+type node struct {
+	children map[string]*node
+	subs     map[uuid.UUID]*subscription
+	// groups хранит участников очередей в порядке вступления
+	groups map[string][]*subscription
+}
+
+func newNode() *node {
+	return &node{
+		children: make(map[string]*node),
+		subs:     make(map[uuid.UUID]*subscription),
+		groups:   make(map[string][]*subscription),
+	}
+}
+
+func (n *node) empty() bool {
+	return len(n.children) == 0 && len(n.subs) == 0 && len(n.groups) == 0
+}
+
+// clone копирует узел без потомков: дочерние узлы остаются общими
+func (n *node) clone() *node {
+	c := &node{
+		children: make(map[string]*node, len(n.children)),
+		subs:     make(map[uuid.UUID]*subscription, len(n.subs)),
+		groups:   make(map[string][]*subscription, len(n.groups)),
+	}
+	for token, child := range n.children {
+		c.children[token] = child
+	}
+	for id, sub := range n.subs {
+		c.subs[id] = sub
+	}
+	for name, members := range n.groups {
+		c.groups[name] = members
+	}
+	return c
+}
+
+func (n *node) add(sub *subscription) {
+	if sub.group == "" {
+		n.subs[sub.id] = sub
+		return
+	}
+	// Дописываем в копию: срез участников мог забрать Publish
+	members := n.groups[sub.group]
+	n.groups[sub.group] = append(members[:len(members):len(members)], sub)
+}
+
+func (n *node) delete(sub *subscription) {
+	if sub.group == "" {
+		delete(n.subs, sub.id)
+		return
+	}
+
+	members := n.groups[sub.group]
+	for i, member := range members {
+		if member == sub {
+			// Копируем, чтобы не менять срез, который мог забрать Publish
+			rest := make([]*subscription, 0, len(members)-1)
+			rest = append(rest, members[:i]...)
+			members = append(rest, members[i+1:]...)
+			break
+		}
+	}
+	if len(members) == 0 {
+		delete(n.groups, sub.group)
+	} else {
+		n.groups[sub.group] = members
+	}
+}
+
+// trie хранит подписки по шаблонам тем. Поиск подписчиков для темы
+// зависит от ее глубины, а не от общего числа подписок. Дерево не меняется
+// после создания: insert и remove копируют путь от корня до изменяемого узла,
+// поэтому читатели работают со снимком без блокировок.
+type trie struct {
+	root *node
+}
+
+func newTrie() *trie {
+	return &trie{root: newNode()}
+}
+
+// insert возвращает дерево с добавленной подпиской
+func (t *trie) insert(pattern string, sub *subscription) *trie {
+	root := t.root.clone()
+	n := root
+	for _, token := range tokenize(pattern) {
+		child, ok := n.children[token]
+		if ok {
+			child = child.clone()
+		} else {
+			child = newNode()
+		}
+		n.children[token] = child
+		n = child
+	}
+	n.add(sub)
+	return &trie{root: root}
+}
+
+// remove возвращает дерево без подписки, опустевшие узлы в него не попадают
+func (t *trie) remove(sub *subscription) *trie {
+	tokens := tokenize(sub.subject)
+	path := make([]*node, 0, len(tokens)+1)
+
+	n := t.root
+	path = append(path, n)
+	for _, token := range tokens {
+		child, ok := n.children[token]
+		if !ok {
+			return t
+		}
+		n = child
+		path = append(path, n)
+	}
+
+	child := n.clone()
+	child.delete(sub)
+	for i := len(tokens) - 1; i >= 0; i-- {
+		parent := path[i].clone()
+		if child.empty() {
+			delete(parent.children, tokens[i])
+		} else {
+			parent.children[tokens[i]] = child
+		}
+		child = parent
+	}
+	return &trie{root: child}
+}
+
+// lookup возвращает узел, соответствующий шаблону темы
+func (t *trie) lookup(pattern string) (*node, bool) {
+	n := t.root
+	for _, token := range tokenize(pattern) {
+		child, ok := n.children[token]
+		if !ok {
+			return nil, false
+		}
+		n = child
+	}
+	return n, true
+}
+
+// walk обходит все подписки дерева
+func (t *trie) walk(fn func(*subscription)) {
+	walkNode(t.root, fn)
+}
+
+func walkNode(n *node, fn func(*subscription)) {
+	for _, sub := range n.subs {
+		fn(sub)
+	}
+	for _, members := range n.groups {
+		for _, sub := range members {
+			fn(sub)
+		}
+	}
+	for _, child := range n.children {
+		walkNode(child, fn)
+	}
+}
+
+// matcher получает подписки, совпавшие с темой: обычные подписки
+// по одной, участников очереди - срезом на каждый узел
+type matcher interface {
+	subscriber(sub *subscription)
+	group(name string, members []*subscription)
+}
+
+// match передает в m все подписки, шаблон которых совпадает с темой
+func (t *trie) match(subject string, m matcher) {
+	matchNode(t.root, tokenize(subject), m)
+}
+
+func matchNode(n *node, tokens []string, m matcher) {
+	if len(tokens) == 0 {
+		visitNode(n, m)
+		return
+	}
+
+	// Токены-шаблоны в самой теме не сопоставляются буквально,
+	// иначе подписка на шаблон получила бы сообщение дважды
+	if token := tokens[0]; token != wildcardOne && token != wildcardTail {
+		if child, ok := n.children[token]; ok {
+			matchNode(child, tokens[1:], m)
+		}
+	}
+	if child, ok := n.children[wildcardOne]; ok {
+		matchNode(child, tokens[1:], m)
+	}
+	if child, ok := n.children[wildcardTail]; ok {
+		visitNode(child, m)
+	}
+}
+
+func visitNode(n *node, m matcher) {
+	for _, sub := range n.subs {
+		m.subscriber(sub)
+	}
+	for name, members := range n.groups {
+		m.group(name, members)
+	}
+}
@@ -0,0 +1,99 @@
+package subpub
+
+import "fmt"
+
+// Typed - типизированная обертка над SubPub. Значения кодируются кодеком
+// при публикации, поэтому их можно сохранить в журнал и отдать клиентам
+// через gRPC, а подписчики получают уже декодированное значение T.
+type Typed[T any] struct {
+	ps      SubPub
+	codec   Codec
+	onError func(msg *Message, err error)
+}
+
+// TypedOption настраивает Typed при создании
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	onError func(msg *Message, err error)
+}
+
+// WithDecodeErrorHandler задает обработчик сообщений, которые не удалось
+// привести к типу подписки. По умолчанию такие сообщения пропускаются.
+func WithDecodeErrorHandler(fn func(msg *Message, err error)) TypedOption {
+	return func(o *typedOptions) {
+		o.onError = fn
+	}
+}
+
+// NewTyped создает типизированную обертку над брокером
+func NewTyped[T any](ps SubPub, codec Codec, opts ...TypedOption) *Typed[T] {
+	var o typedOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Typed[T]{
+		ps:      ps,
+		codec:   codec,
+		onError: o.onError,
+	}
+}
+
+// Publish кодирует значение и публикует его в тему
+func (t *Typed[T]) Publish(subject string, v T) error {
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("subpub: encode %T: %w", v, err)
+	}
+	return t.ps.PublishMsg(&Message{
+		Subject:     subject,
+		ContentType: t.codec.ContentType(),
+		Data:        data,
+	})
+}
+
+// Subscribe подписывает обработчик на декодированные значения
+func (t *Typed[T]) Subscribe(subject string, cb func(T), opts ...SubscribeOption) (Subscription, error) {
+	return t.ps.SubscribeMsg(subject, "", t.handler(cb), opts...)
+}
+
+// SubscribeQueue подписывает участника очереди group на декодированные значения
+func (t *Typed[T]) SubscribeQueue(subject, group string, cb func(T), opts ...SubscribeOption) (Subscription, error) {
+	return t.ps.SubscribeMsg(subject, group, t.handler(cb), opts...)
+}
+
+func (t *Typed[T]) handler(cb func(T)) MsgHandler {
+	return func(msg *Message) {
+		v, err := t.decode(msg)
+		if err != nil {
+			if t.onError != nil {
+				t.onError(msg, err)
+			}
+			return
+		}
+		cb(v)
+	}
+}
+
+// decode приводит данные сообщения к T. Данные с типом содержимого кодека
+// всегда декодируются, иначе Typed[[]byte] и Typed[any] получали бы
+// закодированные байты. Значение T, опубликованное в обход Typed,
+// передается как есть.
+func (t *Typed[T]) decode(msg *Message) (T, error) {
+	var v T
+	if msg.ContentType != t.codec.ContentType() {
+		if d, ok := msg.Data.(T); ok {
+			return d, nil
+		}
+	}
+	switch d := msg.Data.(type) {
+	case []byte:
+		err := t.codec.Unmarshal(d, &v)
+		return v, err
+	case string:
+		err := t.codec.Unmarshal([]byte(d), &v)
+		return v, err
+	default:
+		return v, fmt.Errorf("subpub: cannot decode %T into %T", msg.Data, v)
+	}
+}